@@ -0,0 +1,389 @@
+package codegen
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseArgs parses the arguments of a codegen tag or directive, a comma
+// separated list of `key=value` pairs, e.g. `type=string,fields=[ID,Name]`.  A
+// value may be:
+//
+//   - Bare text, running up to the next comma that isn't within brackets or
+//     parentheses, so types like `map[string]int` or `[]string` need no
+//     quoting.  It's trimmed, and `%` escapes and `+` are decoded like a query
+//     string for compatibility.
+//   - A double quoted go string literal, or single quoted text, either of which
+//     may contain commas, `=` or anything else.
+//   - A list of bare or quoted items in square brackets, ending the value, which
+//     is stored in the form `[A,B]`, quoting items where needed.  See
+//     `$.ArgList`.
+//
+// A key without a value, e.g. `exported`, has an empty value, which `$.ArgBool`
+// treats as true.  If a key is given more than once, the first occurrence wins.
+func parseArgs(tag string) (map[string]string, error) {
+	p := &argParser{input: tag}
+	args := make(map[string]string)
+	for p.skipSpace(); !p.done(); p.skipSpace() {
+		// Empty arguments, e.g. in `a,,b`, are skipped like a query string's.
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		key := strings.TrimSpace(p.scanUntil("=,"))
+		if key == "" {
+			return nil, p.errorf("missing argument name")
+		}
+
+		value := ""
+		if p.peek() == '=' {
+			p.pos++
+			var err error
+			if value, err = p.value(); err != nil {
+				return nil, errors.Wrapf(err, "argument %s", key)
+			}
+		}
+		if _, seen := args[key]; !seen {
+			args[key] = value
+		}
+
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected a comma after argument %s", key)
+		}
+		p.pos++
+	}
+	return args, nil
+}
+
+// parseArgList parses an argument value as a list.  Values not written as a
+// list are a list of one item.
+func parseArgList(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(value, "[") {
+		return []string{value}, nil
+	}
+	p := &argParser{input: value}
+	items, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	// A value like `[]string` only starts with brackets.
+	if p.skipSpace(); !p.done() {
+		return []string{value}, nil
+	}
+	return items, nil
+}
+
+type argParser struct {
+	input string
+	pos   int
+}
+
+func (p *argParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *argParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *argParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *argParser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("%s at offset %d of %q", fmt.Sprintf(format, args...), p.pos, p.input)
+}
+
+// scanUntil returns the text up to the first of `stops` that isn't nested
+// within brackets or parentheses.
+func (p *argParser) scanUntil(stops string) string {
+	start, depth := p.pos, 0
+	for ; !p.done(); p.pos++ {
+		c := p.peek()
+		switch {
+		case c == '[' || c == '(' || c == '{':
+			depth++
+		case (c == ']' || c == ')' || c == '}') && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(stops, c) != -1:
+			return p.input[start:p.pos]
+		}
+	}
+	return p.input[start:]
+}
+
+// value parses an argument value.
+func (p *argParser) value() (string, error) {
+	p.skipSpace()
+	switch p.peek() {
+	case '"', '\'':
+		return p.quoted()
+	case '[':
+		start := p.pos
+		items, err := p.list()
+		if err != nil {
+			return "", err
+		}
+		// Only a list that ends the value is one, so that types like
+		// `[]string` or `[4]byte` can be passed as bare text.
+		if p.skipSpace(); p.done() || p.peek() == ',' {
+			return formatArgList(items), nil
+		}
+		p.pos = start
+	}
+	return p.bare(","), nil
+}
+
+func (p *argParser) bare(stops string) string {
+	text := strings.TrimSpace(p.scanUntil(stops))
+	if strings.ContainsAny(text, "%+") {
+		if unescaped, err := url.QueryUnescape(text); err == nil {
+			return unescaped
+		}
+	}
+	return text
+}
+
+// quoted parses a double quoted go string literal, or single quoted text with
+// no escapes.
+func (p *argParser) quoted() (string, error) {
+	quote := p.peek()
+	start := p.pos
+	for p.pos++; !p.done(); p.pos++ {
+		switch p.peek() {
+		case '\\':
+			if quote == '"' {
+				p.pos++
+			}
+		case quote:
+			p.pos++
+			text := p.input[start:p.pos]
+			if quote == '\'' {
+				return text[1 : len(text)-1], nil
+			}
+			unquoted, err := strconv.Unquote(text)
+			if err != nil {
+				return "", errors.Wrapf(err, "invalid string %s", text)
+			}
+			return unquoted, nil
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+func (p *argParser) list() ([]string, error) {
+	start := p.pos
+	p.pos++ // [
+	var items []string
+	for {
+		p.skipSpace()
+		if p.done() {
+			p.pos = start
+			return nil, p.errorf("unterminated list")
+		}
+		// This also allows a trailing comma.
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+
+		var item string
+		if c := p.peek(); c == '"' || c == '\'' {
+			var err error
+			if item, err = p.quoted(); err != nil {
+				return nil, err
+			}
+		} else {
+			item = p.bare(",]")
+		}
+		items = append(items, item)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return items, nil
+		default:
+			p.pos = start
+			return nil, p.errorf("unterminated list")
+		}
+	}
+}
+
+// formatArgList formats `items` as a list that `parseArgList` parses back.
+func formatArgList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		if item == "" || item != strings.TrimSpace(item) ||
+			strings.ContainsAny(item, ",[]()'\"%+") {
+			item = strconv.Quote(item)
+		}
+		quoted[i] = item
+	}
+	return "[" + strings.Join(quoted, ",") + "]"
+}
+
+// ArgList returns the argument `name` as a list, e.g. `[ID,Name]`.  A value not
+// written as a list is a list of one item, and a missing argument an empty one.
+func (c *TemplateContext) ArgList(name string) ([]string, error) {
+	items, err := parseArgList(c.Args[name])
+	return items, errors.Wrapf(err, "argument %s", name)
+}
+
+// ArgBool returns the argument `name` as a boolean.  It's false if the argument
+// is missing, and true if it's given without a value, e.g. `exported`.
+func (c *TemplateContext) ArgBool(name string) (bool, error) {
+	value, ok := c.Args[name]
+	if !ok {
+		return false, nil
+	} else if value == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(value)
+	return b, errors.Wrapf(err, "argument %s", name)
+}
+
+// ArgInt returns the argument `name` as an integer, or 0 if it's missing.
+func (c *TemplateContext) ArgInt(name string) (int, error) {
+	value, ok := c.Args[name]
+	if !ok {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(value)
+	return i, errors.Wrapf(err, "argument %s", name)
+}
+
+// ArgFloat returns the argument `name` as a floating point number, or 0 if it's
+// missing.
+func (c *TemplateContext) ArgFloat(name string) (float64, error) {
+	value, ok := c.Args[name]
+	if !ok {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, errors.Wrapf(err, "argument %s", name)
+}
+
+// typeArgPrefix may start the value of an argument declared as a type, e.g.
+// `type=@Message`, to mark it as one for the reader.
+const typeArgPrefix = "@"
+
+// qualifiedTypeName matches a type named by its package path, e.g.
+// `github.com/x/y.Z`.
+var qualifiedTypeName = regexp.MustCompile(`^[\w./~-]+\.\w+$`)
+
+// typeLookup is implemented by `TypeInfo`s that can look up named types by
+// package path, such as `GenContext`.
+type typeLookup interface {
+	lookupType(pkgPath, name string) (types.Type, error)
+}
+
+func (ctx *GenContext) lookupType(pkgPath, name string) (types.Type, error) {
+	pkg, ok := ctx.packages[pkgPath]
+	if !ok {
+		return nil, errors.Errorf("package %s is not imported by the package being generated", pkgPath)
+	}
+	obj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+	if !ok {
+		return nil, errors.Errorf("type %s not found in package %s", name, pkgPath)
+	}
+	return obj.Type(), nil
+}
+
+// resolveTypeArgs resolves the arguments `names`, which are declared as types,
+// replacing their values with the type as rendered by `$.TypeString`, and
+// recording the types for `$.ArgType`.
+func (c *TemplateContext) resolveTypeArgs(names []string) error {
+	for _, name := range names {
+		value, ok := c.Args[name]
+		if !ok {
+			continue
+		}
+		t, err := c.resolveType(strings.TrimPrefix(value, typeArgPrefix))
+		if err != nil {
+			return errors.Wrapf(err, "argument %s", name)
+		}
+		if c.argTypes == nil {
+			c.argTypes = make(map[string]types.Type)
+		}
+		c.argTypes[name] = t
+	}
+
+	if len(c.argTypes) == 0 {
+		return nil
+	}
+	// Copy the arguments so the invocation's aren't modified.
+	args := make(map[string]string, len(c.Args))
+	for name, value := range c.Args {
+		args[name] = value
+	}
+	for name, t := range c.argTypes {
+		args[name] = c.TypeString(t)
+	}
+	c.Args = args
+	return nil
+}
+
+// resolveType resolves `expr`, a type expression evaluated in the scope of the
+// file declaring the type the template was invoked on, e.g. `Message`,
+// `[]*Message` or `time.Duration`, or failing that a type qualified by its
+// package path, e.g. `github.com/x/y.Z`.
+func (c *TemplateContext) resolveType(expr string) (types.Type, error) {
+	obj := c.Struct.Obj()
+	pos := obj.Pos()
+	if obj.Pkg().Scope().Innermost(pos) == nil {
+		// The package wasn't type checked from source, so only its package
+		// scope is known.
+		pos = token.NoPos
+	}
+	tv, err := types.Eval(token.NewFileSet(), obj.Pkg(), pos, expr)
+	if err != nil {
+		if !qualifiedTypeName.MatchString(expr) {
+			return nil, err
+		}
+		lastDot := strings.LastIndex(expr, ".")
+		pkgPath, name := expr[:lastDot], expr[lastDot+1:]
+		if lookup, ok := c.info.(typeLookup); ok {
+			return lookup.lookupType(pkgPath, name)
+		}
+		return c.info.GetType(expr)
+	}
+	if !tv.IsType() {
+		return nil, errors.Errorf("%s is not a type", expr)
+	}
+	return tv.Type, nil
+}
+
+// ArgType returns the type named by the argument `name`, which the template's
+// front matter must declare with `type: true`, e.g. `type=@Message`,
+// `type=@time.Duration` or `type=@github.com/x/y.Z`.  The package of a type
+// given by its path must be imported by the package being generated.
+func (c *TemplateContext) ArgType(name string) (types.Type, error) {
+	t, ok := c.argTypes[name]
+	if !ok {
+		return nil, errors.Errorf("argument %s is not declared as a type", name)
+	}
+	return t, nil
+}
@@ -4,22 +4,72 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	codegen "github.com/CyborgMaster/go-codegen"
 )
 
 var versionFlag = flag.Bool("v", false, "prints the version number")
 var runtimeFlag = flag.Bool("runtime", false, "prints the go runtime version number")
+var checkFlag = flag.Bool(
+	"check",
+	false,
+	"checks that generated files are up to date instead of writing them, "+
+		"printing a diff and exiting non-zero if any are stale",
+)
+var outputFlag = flag.String(
+	"output",
+	codegen.DefaultOutputName,
+	"template for the name of each generated file, e.g. "+
+		"\"zz_generated_{{.Base}}.go\".\n"+
+		"{{.Base}} is the source file name without \".go\" and {{.Package}} its package name",
+)
+var combinedFlag = flag.Bool(
+	"combined",
+	false,
+	"generates a single file per package (named by -output with {{.Base}} set to \""+
+		codegen.CombinedBase+"\") instead of one per source file",
+)
+var addImportsFlag = flag.Bool(
+	"add-imports",
+	false,
+	"imports standard library packages used by generated code that templates didn't import",
+)
+var saveBrokenFlag = flag.Bool(
+	"save-broken",
+	false,
+	"saves the raw output of templates that generate invalid code to <file>.broken",
+)
+var lineDirectivesFlag = flag.Bool(
+	"line-directives",
+	false,
+	"annotates generated code with //line directives so compiler errors and "+
+		"stack traces point at the templates that generated it",
+)
+var templatePathFlag = flag.String(
+	"template-path",
+	os.Getenv(codegen.TemplatePathEnv),
+	"directories to look for templates in when they aren't next to their gen type, "+
+		"separated by \""+string(os.PathListSeparator)+"\".\n"+
+		"Defaults to $"+codegen.TemplatePathEnv,
+)
+var stdoutFlag bool
 
 func init() {
+	const stdoutUsage = "prints generated code to stdout instead of writing it to disk"
+	flag.BoolVar(&stdoutFlag, "stdout", false, stdoutUsage)
+	flag.BoolVar(&stdoutFlag, "n", false, stdoutUsage+" (shorthand for -stdout)")
+
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "Usage of go-codegen: [flags] <paths...>")
 		flag.PrintDefaults()
 		fmt.Fprintln(
 			flag.CommandLine.Output(),
-			"  paths: files to parse and generate code for.",
+			"  paths: files to parse and generate code for, or package patterns\n"+
+				"    (e.g. ./...) to generate code for every tagged file they contain.",
 		)
 	}
 }
@@ -38,7 +88,29 @@ func main() {
 
 	args := flag.Args()
 	if len(args) == 0 {
-		log.Fatalln("expected one or more go files are arguments")
+		log.Fatalln("expected one or more go files or package patterns as arguments")
+	}
+
+	if *checkFlag && stdoutFlag {
+		log.Fatalln("-check and -stdout cannot be used together")
+	}
+
+	generator := &codegen.Generator{
+		Check:      *checkFlag,
+		Stdout:     stdoutFlag,
+		OutputName: *outputFlag,
+		Combined:   *combinedFlag,
+
+		AddMissingImports: *addImportsFlag,
+		SaveBroken:        *saveBrokenFlag,
+		LineDirectives:    *lineDirectivesFlag,
+		TemplatePath:      filepath.SplitList(*templatePathFlag),
+	}
+	if !allGoFiles(args) {
+		if err := generator.ProcessPackages(args...); err != nil {
+			log.Fatalln(err)
+		}
+		return
 	}
 
 	filePaths := make([]string, len(args), len(args))
@@ -49,7 +121,18 @@ func main() {
 			log.Fatal(err)
 		}
 	}
-	if err := codegen.ProcessFile(filePaths...); err != nil {
+	if err := generator.ProcessFile(filePaths...); err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// allGoFiles reports whether every argument names a go file, as opposed to a
+// package pattern.
+func allGoFiles(args []string) bool {
+	for _, arg := range args {
+		if !strings.HasSuffix(arg, ".go") {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,10 @@
 package codegen
 
 import (
+	"go/ast"
 	"go/token"
 	"go/types"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -15,14 +17,41 @@ import (
 // Context represents the context in which a code generation operation is run.
 type GenContext struct {
 	PackageName string
+	// AddMissingImports makes `Output` import the standard library packages the
+	// generated code uses without importing them, like goimports.
+	AddMissingImports bool
+	// LineDirectives makes `Output` annotate the generated code with `//line`
+	// directives pointing at the template line each line was generated by, so
+	// compiler errors and stack traces refer to the template instead.
+	LineDirectives bool
+	// TemplatePath lists directories to look for templates in when they aren't
+	// found in the directory of their gen type.  See `Generator.TemplatePath`.
+	TemplatePath []string
 
-	templates       map[string]*template.Template
+	templates       map[string]parsedTemplate // gen type name -> template
+	packageSources  map[string]packageSource
+	dir             string // directory of the root package
 	imports         []string
 	importsSeen     map[string]struct{}
+	importNames     map[string]string // import path -> name in generated code
+	importPaths     map[string]string // name in generated code -> import path
+	rootScope       *types.Scope
 	fset            *token.FileSet
 	packages        map[string]*types.Package
 	invocationsSeen []invocationSeen
 	generated       []string
+	// sources records where each entry of `generated` came from.
+	sources []generatedSource
+
+	// syntax holds the parsed files of the root package, if loaded, docs
+	// indexes their doc comments and fields their struct fields.
+	syntax []*ast.File
+	docs   map[token.Pos]*ast.CommentGroup
+	fields map[token.Pos]*ast.Field
+
+	// outputFiles holds the paths of the files being generated for the
+	// package, whose declarations are ignored.
+	outputFiles map[string]bool
 }
 
 type invocationSeen struct {
@@ -31,6 +60,16 @@ type invocationSeen struct {
 	Args        map[string]string
 }
 
+// generatedSource records the invocation and template that generated a chunk
+// of code, along with the template position of each of its lines.
+type generatedSource struct {
+	invocation invocationSeen
+	template   string
+	lines      []templatePos
+	// site is the declaration of the type the template was invoked on.
+	site token.Position
+}
+
 func NewGenContext(fset *token.FileSet, rootPackage *types.Package) *GenContext {
 	allPackages := typeutil.Dependencies(rootPackage)
 	packageMap := make(map[string]*types.Package)
@@ -39,8 +78,11 @@ func NewGenContext(fset *token.FileSet, rootPackage *types.Package) *GenContext
 	}
 	ctx := &GenContext{
 		PackageName: rootPackage.Name(),
-		templates:   make(map[string]*template.Template),
+		templates:   make(map[string]parsedTemplate),
 		importsSeen: make(map[string]struct{}),
+		importNames: make(map[string]string),
+		importPaths: make(map[string]string),
+		rootScope:   rootPackage.Scope(),
 		fset:        fset,
 		packages:    packageMap,
 	}
@@ -52,7 +94,7 @@ func (ctx *GenContext) RunTemplate(invocation Invocation, aStruct *types.Named)
 	// If this exact invocation has already occurred (genType + structType +
 	// args), then don't do it again.
 	onStruct := invocationSeen{
-		GenTypeName: fullTypeName(invocation.GenType),
+		GenTypeName: instanceName(invocation.GenType),
 		StructName:  fullTypeName(aStruct),
 		Args:        invocation.Args,
 	}
@@ -70,20 +112,40 @@ func (ctx *GenContext) RunTemplate(invocation Invocation, aStruct *types.Named)
 		return errors.Wrap(err, "getting template")
 	}
 
-	generated, err := RunTemplate(template, aStruct, invocation.Args, ctx)
+	generated, err := runTemplate(template, aStruct, invocation, ctx)
 	if err != nil {
 		return err
 	}
+	generated, lines := stripLineMarkers(generated)
 	ctx.generated = append(ctx.generated, generated)
+	ctx.sources = append(ctx.sources, generatedSource{
+		invocation: onStruct,
+		template:   template.Name(),
+		lines:      lines,
+		site:       ctx.fset.Position(aStruct.Obj().Pos()),
+	})
 	return nil
 }
 
 func (ctx *GenContext) AddImport(pkg string) {
+	ctx.ImportName(pkg)
+}
+
+// ImportName imports the package with the path `pkg`, if it isn't already, and
+// returns the name it is referred to by in the generated code.  This is the
+// package's own name, unless that is already taken by another import or a
+// declaration in the package being generated, in which case a unique alias is
+// chosen.  It returns an empty string for the package being generated.
+func (ctx *GenContext) ImportName(pkg string) string {
 	if _, seen := ctx.importsSeen[pkg]; seen {
-		return
+		return ctx.importNames[pkg]
 	}
+	name := ctx.uniqueImportName(pkg)
 	ctx.imports = append(ctx.imports, pkg)
 	ctx.importsSeen[pkg] = struct{}{}
+	ctx.importNames[pkg] = name
+	ctx.importPaths[name] = pkg
+	return name
 }
 
 func (ctx *GenContext) Imports() []string {
@@ -119,23 +181,77 @@ func (ctx *GenContext) GetType(fullName string) (types.Type, error) {
 	return t.Type().Underlying(), nil
 }
 
+// inOutput returns true if `obj` is declared in one of the files being
+// generated.
+func (ctx *GenContext) inOutput(obj types.Object) bool {
+	return ctx.outputFiles[ctx.fset.Position(obj.Pos()).Filename]
+}
+
 func fullTypeName(named *types.Named) string {
 	return named.Obj().Pkg().Path() + "." + named.Obj().Name()
 }
 
-func (ctx *GenContext) templateForGenType(genType *types.Named) (*template.Template, error) {
+// instanceName returns the full name of `named` followed by the type arguments
+// it's instantiated with, if any, e.g. `example.com/pkg.cacheGen[string]`.
+func instanceName(named *types.Named) string {
+	name := fullTypeName(named)
+	if args := typeArgs(named); len(args) > 0 {
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = types.TypeString(arg, nil)
+		}
+		name += "[" + strings.Join(names, ", ") + "]"
+	}
+	return name
+}
+
+// parsedTemplate is the template set for a gen type, along with the front
+// matter of its main template, or nil if it has none.
+type parsedTemplate struct {
+	*template.Template
+	frontMatter *FrontMatter
+}
+
+func (ctx *GenContext) templateForGenType(genType *types.Named) (parsedTemplate, error) {
 	fullName := fullTypeName(genType)
 	if template, ok := ctx.templates[fullName]; ok {
 		return template, nil
 	}
 
-	pos := genType.Obj().Pos()
-	fpath := ctx.fset.Position(pos).Filename
-	templatePath := filepath.Join(filepath.Dir(fpath), genType.Obj().Name()+".tmpl")
-	template, err := ParseTemplate(templatePath)
+	candidates := ctx.templateCandidates(genType)
+	for _, templatePath := range candidates {
+		if _, err := os.Stat(templatePath); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return parsedTemplate{}, errors.Wrap(err, "finding template")
+		}
+
+		t, fm, err := parseTemplate(templatePath)
+		if err != nil {
+			return parsedTemplate{}, errors.Wrap(err, "parsing template")
+		}
+		instrumentTemplate(t, filepath.Dir(templatePath))
+		template := parsedTemplate{Template: t, frontMatter: fm}
+		ctx.templates[fullName] = template
+		return template, nil
+	}
+
+	name := genType.Obj().Name() + ".tmpl"
+	t, fm, err := registeredTemplate(genType.Obj().Pkg().Path(), name)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing template")
+		return parsedTemplate{}, errors.Wrap(err, "parsing registered template")
 	}
-	ctx.templates[fullName] = template
-	return template, nil
+	if t != nil {
+		instrumentTemplate(t, "")
+		template := parsedTemplate{Template: t, frontMatter: fm}
+		ctx.templates[fullName] = template
+		return template, nil
+	}
+	candidates = append(candidates, name+" registered for "+genType.Obj().Pkg().Path())
+
+	return parsedTemplate{}, errors.Errorf(
+		"no template found for %s, tried:\n  %s",
+		fullName,
+		strings.Join(candidates, "\n  "),
+	)
 }
@@ -0,0 +1,201 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change in a
+// unified diff.
+const diffContext = 3
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffLine struct {
+	op   diffOp
+	text string
+}
+
+// unifiedDiff returns a unified diff that transforms `from` into `to`, or an
+// empty string if they are identical.
+func unifiedDiff(fromName, toName string, from, to []byte) string {
+	if bytes.Equal(from, to) {
+		return ""
+	}
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Walk the edit script, emitting a hunk for each run of changes that are
+	// within `2 * diffContext` lines of each other.
+	fromLine, toLine := 0, 0
+	for i := 0; i < len(lines); {
+		if lines[i].op == diffEqual {
+			i++
+			fromLine++
+			toLine++
+			continue
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].op != diffEqual {
+				end++
+				continue
+			}
+			// Look ahead to see if another change is close enough to be merged
+			// into this hunk.
+			next := end
+			for next < len(lines) && lines[next].op == diffEqual {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end += diffContext
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		hunkFrom, hunkTo := fromLine-(i-start), toLine-(i-start)
+		fromCount, toCount := 0, 0
+		var body strings.Builder
+		for _, l := range lines[start:end] {
+			switch l.op {
+			case diffEqual:
+				body.WriteString(" ")
+				fromCount++
+				toCount++
+			case diffDelete:
+				body.WriteString("-")
+				fromCount++
+			case diffInsert:
+				body.WriteString("+")
+				toCount++
+			}
+			body.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(
+			&out, "@@ -%s +%s @@\n%s",
+			hunkRange(hunkFrom, fromCount), hunkRange(hunkTo, toCount), body.String(),
+		)
+
+		for _, l := range lines[i:end] {
+			if l.op != diffInsert {
+				fromLine++
+			}
+			if l.op != diffDelete {
+				toLine++
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the line range of one side of a hunk header.  `start` is
+// the zero based index of the first line in the hunk.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range refers to the line before the change.
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script from `a` to `b` using Myers'
+// algorithm.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// `trace[d]` holds the furthest reaching x for each diagonal k before round
+	// d, which lets us walk the path back once the end has been reached.
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrackDiff(a, b []string, trace [][]int) []diffLine {
+	var reversed []diffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		// Each snapshot covers diagonals -d-1 through d+1.
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffLine{diffEqual, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, diffLine{diffInsert, b[y-1]})
+				y--
+			} else {
+				reversed = append(reversed, diffLine{diffDelete, a[x-1]})
+				x--
+			}
+		}
+	}
+
+	lines := make([]diffLine, len(reversed))
+	for i, l := range reversed {
+		lines[len(reversed)-1-i] = l
+	}
+	return lines
+}
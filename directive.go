@@ -0,0 +1,147 @@
+package codegen
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// directivePrefix starts a comment line that invokes a template on the type
+// whose declaration it documents, e.g. `//codegen:stackGen type=string`.  Like
+// `//go:` directives, there is no space after the slashes.
+const directivePrefix = "//codegen:"
+
+// InvocationsForDirectives returns the template invocations requested by the
+// `//codegen:` directives in `doc`, the doc comment of a type declared in
+// `file` of package `pkg`.  A directive names its gen type followed by the same
+// args a `codegen` tag accepts:
+//
+//	//codegen:stackGen type=string,other=arg
+//	//codegen:otherpkg.listGen
+//	//codegen:cacheGen[string] size=10
+//
+// An unqualified gen type is looked up in `pkg`, a qualified one in the
+// package imported under that name by `file`.  A generic gen type is given its
+// type arguments in brackets, resolved like any type in `file`.
+func InvocationsForDirectives(
+	doc *ast.CommentGroup,
+	file *ast.File,
+	pkg *types.Package,
+) ([]Invocation, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	var invocations []Invocation
+	for _, comment := range doc.List {
+		if !strings.HasPrefix(comment.Text, directivePrefix) {
+			continue
+		}
+		directive := strings.TrimSpace(strings.TrimPrefix(comment.Text, directivePrefix))
+		genTypeName, argsText := splitGenTypeName(directive)
+
+		baseName := genTypeName
+		if i := strings.Index(genTypeName, "["); i != -1 {
+			baseName = genTypeName[:i]
+		}
+		genType, err := lookupGenType(baseName, file, pkg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving directive %q", comment.Text)
+		}
+		if baseName != genTypeName {
+			genType, err = instantiateGenType(genType, genTypeName, comment.Pos(), pkg)
+		} else if genType.TypeParams().Len() > 0 {
+			err = errors.Errorf("%s requires type arguments", genTypeName)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving directive %q", comment.Text)
+		}
+
+		args, err := parseArgs(argsText)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing args of directive %q", comment.Text)
+		}
+
+		withNested, err := invocationWithNested(genType, args)
+		if err != nil {
+			return nil, err
+		}
+		invocations = append(invocations, withNested...)
+	}
+
+	return invocations, nil
+}
+
+// lookupGenType resolves the, possibly package qualified, name of a gen type
+// referenced from `file`.
+func lookupGenType(name string, file *ast.File, pkg *types.Package) (*types.Named, error) {
+	scope := pkg.Scope()
+	typeName := name
+	if dot := strings.Index(name, "."); dot != -1 {
+		imported, err := importedPackage(name[:dot], file, pkg)
+		if err != nil {
+			return nil, err
+		}
+		scope = imported.Scope()
+		typeName = name[dot+1:]
+	}
+
+	obj, ok := scope.Lookup(typeName).(*types.TypeName)
+	if !ok {
+		return nil, errors.Errorf("%s is not a type", name)
+	}
+	genType, ok := obj.Type().(*types.Named)
+	if !ok {
+		return nil, errors.Errorf("expected %s to be a named type", name)
+	}
+	return genType, nil
+}
+
+// importedPackage returns the package imported by `file` under `name`.
+func importedPackage(name string, file *ast.File, pkg *types.Package) (*types.Package, error) {
+	imports := make(map[string]*types.Package)
+	for _, i := range pkg.Imports() {
+		imports[i.Path()] = i
+	}
+
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		imported, ok := imports[path]
+		if !ok {
+			continue
+		}
+		importedName := imported.Name()
+		if spec.Name != nil {
+			importedName = spec.Name.Name
+		}
+		if importedName == name {
+			return imported, nil
+		}
+	}
+	return nil, errors.Errorf("package %s is not imported", name)
+}
+
+// typeDocs maps the name of each type declared in `file` onto its doc comment.
+func typeDocs(file *ast.File) map[string]*ast.CommentGroup {
+	docs := make(map[string]*ast.CommentGroup)
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			if doc := specDoc(genDecl, typeSpec.Doc); doc != nil {
+				docs[typeSpec.Name.Name] = doc
+			}
+		}
+	}
+	return docs
+}
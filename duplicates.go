@@ -0,0 +1,259 @@
+package codegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// DuplicateDeclarationError is returned by `Render` when templates generate the
+// same top level declaration more than once, or one already declared in the
+// package, which would otherwise only be reported when compiling the generated
+// code.
+type DuplicateDeclarationError struct {
+	// Path is the file the code was generated for.
+	Path       string
+	Duplicates []DuplicateDeclaration
+}
+
+// DuplicateDeclaration describes a name that generated code declares more than
+// once, or that is already declared.
+type DuplicateDeclaration struct {
+	// Kind is one of "func", "method", "type", "var" or "const".
+	Kind string
+	// Name is the declared name, qualified by the receiver's type name for a
+	// method, e.g. `HelloCommand.MustExecute`.
+	Name string
+	// Generated lists where each generated declaration came from, in the order
+	// they appear in the generated code.
+	Generated []DeclarationSource
+	// Existing is where the name is already declared in the package, or the
+	// zero value if it isn't.
+	Existing token.Position
+}
+
+// DeclarationSource describes the template invocation that generated a
+// declaration.
+type DeclarationSource struct {
+	// Template is the name of the template file that generated the declaration
+	// and TemplateLine the line within it, which is 0 if it isn't known.
+	Template     string
+	TemplateLine int
+	// Type is the fully qualified name of the type the template was invoked on
+	// and Args the arguments it was invoked with.
+	Type string
+	Args map[string]string
+}
+
+func (e *DuplicateDeclarationError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("duplicate declarations in generated code:")
+	for _, d := range e.Duplicates {
+		fmt.Fprintf(&msg, "\n  %s %s", d.Kind, d.Name)
+		for _, src := range d.Generated {
+			fmt.Fprintf(&msg, "\n    generated by %s", src)
+		}
+		if d.Existing.IsValid() {
+			fmt.Fprintf(&msg, "\n    already declared at %s", d.Existing)
+		}
+	}
+	return msg.String()
+}
+
+func (s DeclarationSource) String() string {
+	var str strings.Builder
+	str.WriteString(s.Template)
+	if s.TemplateLine > 0 {
+		fmt.Fprintf(&str, ", line %d", s.TemplateLine)
+	}
+	fmt.Fprintf(&str, ", invoked on %s", s.Type)
+	if len(s.Args) > 0 {
+		fmt.Fprintf(&str, " with %s", formatArgs(s.Args))
+	}
+	return str.String()
+}
+
+// generatedDecl is a top level name declared by generated code.
+type generatedDecl struct {
+	kind string
+	// name is the declared name, and recv the name of the receiver's type for
+	// a method.
+	name string
+	recv string
+	line int
+}
+
+func (d generatedDecl) qualifiedName() string {
+	if d.recv != "" {
+		return d.recv + "." + d.name
+	}
+	return d.name
+}
+
+// checkDuplicateDeclarations returns a `DuplicateDeclarationError` if `file`,
+// the code generated for `filePath` parsed from the source whose chunks start
+// on `startLines`, declares a name more than once, or declares a name that is
+// already declared in the package being generated, or nil if it doesn't.
+func checkDuplicateDeclarations(
+	ctx *GenContext,
+	filePath string,
+	fset *token.FileSet,
+	file *ast.File,
+	startLines []int,
+) error {
+	decls := make(map[string][]generatedDecl)
+	var names []string
+	for _, d := range generatedDecls(fset, file) {
+		key := d.qualifiedName()
+		if _, seen := decls[key]; !seen {
+			names = append(names, key)
+		}
+		decls[key] = append(decls[key], d)
+	}
+
+	var duplicates []DuplicateDeclaration
+	for _, name := range names {
+		generated := decls[name]
+		existing := ctx.existingDecl(filePath, generated[0])
+		if len(generated) < 2 && !existing.IsValid() {
+			continue
+		}
+		d := DuplicateDeclaration{
+			Kind:     generated[0].kind,
+			Name:     name,
+			Existing: existing,
+		}
+		for _, g := range generated {
+			d.Generated = append(d.Generated, ctx.lineSource(startLines, g.line))
+		}
+		duplicates = append(duplicates, d)
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	return &DuplicateDeclarationError{Path: filePath, Duplicates: duplicates}
+}
+
+// generatedDecls returns the top level names declared in `file`, in order.
+// Blank names and `init` functions, which may be declared more than once, are
+// left out.
+func generatedDecls(fset *token.FileSet, file *ast.File) []generatedDecl {
+	var decls []generatedDecl
+	add := func(kind string, name *ast.Ident, recv string) {
+		if name.Name == "_" {
+			return
+		}
+		decls = append(decls, generatedDecl{
+			kind: kind,
+			name: name.Name,
+			recv: recv,
+			line: fset.Position(name.Pos()).Line,
+		})
+	}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				add("method", decl.Name, receiverTypeName(decl.Recv.List[0].Type))
+			} else if decl.Name.Name != "init" {
+				add("func", decl.Name, "")
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					add("type", spec.Name, "")
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						add(decl.Tok.String(), name, "")
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// receiverTypeName returns the name of the type of a method receiver, e.g.
+// `List` for `*List[T]`.
+func receiverTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(e.X)
+	case *ast.ParenExpr:
+		return receiverTypeName(e.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(e.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// existingDecl returns the position of the declaration of `d` in the package
+// being generated, outside the files being generated, or the zero value if
+// there is none.  For a method, this is a method or field with the same name
+// on the receiver's type.
+func (ctx *GenContext) existingDecl(filePath string, d generatedDecl) token.Position {
+	generated := func(obj types.Object) bool {
+		return ctx.inOutput(obj) || ctx.fset.Position(obj.Pos()).Filename == filePath
+	}
+
+	if d.recv == "" {
+		if obj := ctx.rootScope.Lookup(d.name); obj != nil && !generated(obj) {
+			return ctx.fset.Position(obj.Pos())
+		}
+		return token.Position{}
+	}
+
+	typeName, ok := ctx.rootScope.Lookup(d.recv).(*types.TypeName)
+	if !ok {
+		return token.Position{}
+	}
+	named, ok := typeName.Type().(*types.Named)
+	if !ok {
+		return token.Position{}
+	}
+	for i := 0; i < named.NumMethods(); i++ {
+		if m := named.Method(i); m.Name() == d.name && !generated(m) {
+			return ctx.fset.Position(m.Pos())
+		}
+	}
+	if s, ok := named.Underlying().(*types.Struct); ok {
+		for i := 0; i < s.NumFields(); i++ {
+			if f := s.Field(i); f.Name() == d.name {
+				return ctx.fset.Position(f.Pos())
+			}
+		}
+	}
+	return token.Position{}
+}
+
+// lineSource returns the invocation that generated `line` of the generated
+// source, whose chunks start on `startLines`, or the zero value if the line
+// wasn't generated by a template, e.g. it's an import.
+func (ctx *GenContext) lineSource(startLines []int, line int) DeclarationSource {
+	// Find the last chunk starting at or before the line.
+	i := sort.Search(len(startLines), func(i int) bool { return startLines[i] > line }) - 1
+	if i < 0 {
+		return DeclarationSource{}
+	}
+	src := ctx.sources[i]
+	s := DeclarationSource{
+		Template: src.template,
+		Type:     src.invocation.StructName,
+		Args:     src.invocation.Args,
+	}
+	if offset := line - startLines[i]; offset < len(src.lines) {
+		if pos := src.lines[offset]; pos.Line > 0 {
+			s.Template = pos.Template
+			s.TemplateLine = pos.Line
+		}
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package codegen
+
+import (
+	"io/fs"
+	pathpkg "path"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+var registry struct {
+	sync.Mutex
+	templates map[string]fs.FS // package path -> templates
+}
+
+// RegisterTemplates makes the templates in `fsys` available to the gen types
+// declared in the package `pkgPath`, for when they aren't found on disk.  This
+// lets a library compile its templates in, typically from its `init` function:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	func init() {
+//		codegen.RegisterTemplates("example.com/gen", templates)
+//	}
+//
+// The template for a gen type `Foo` is the first file named `Foo.tmpl` found
+// within `fsys`, in lexical order, and is parsed along with the partials in its
+// directory like `ParseTemplate`.  Registering templates for a package again
+// replaces them.
+//
+// Registration only affects generators running in the same program as the
+// library, such as custom generator commands.  The `go-codegen` command finds
+// templates embedded into the package declaring a gen type from its source
+// instead.
+func RegisterTemplates(pkgPath string, fsys fs.FS) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.templates == nil {
+		registry.templates = make(map[string]fs.FS)
+	}
+	registry.templates[pkgPath] = fsys
+}
+
+// registeredTemplate returns the template named `name` registered for the
+// package `pkgPath` along with its front matter, or nil if there is none.
+func registeredTemplate(pkgPath, name string) (*template.Template, *FrontMatter, error) {
+	registry.Lock()
+	fsys, ok := registry.templates[pkgPath]
+	registry.Unlock()
+	if !ok {
+		return nil, nil, nil
+	}
+
+	errFound := errors.New("found")
+	var path string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == name {
+			path = p
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, nil, err
+	}
+	if path == "" {
+		return nil, nil, nil
+	}
+	partials, err := fs.Glob(fsys, pathpkg.Join(pathpkg.Dir(path), partialPattern))
+	if err != nil {
+		return nil, nil, err
+	}
+	paths := []string{path}
+	for _, partial := range partials {
+		if partial != path {
+			paths = append(paths, partial)
+		}
+	}
+
+	files := make([]templateFile, len(paths))
+	for i, p := range paths {
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[i] = templateFile{name: pathpkg.Base(p), content: content}
+	}
+	return parseTemplateFiles(files)
+}
@@ -15,18 +15,27 @@ type stack struct {
 }
 
 type StringStack struct {
-	stackGen `codegen:"type=string"`
+	stackGen `codegen:"type=@string"`
 	stack
 	data []string
 }
 
+// IntStack invokes the stackGen template with a directive instead of a tagged
+// field.
+//
+//codegen:stackGen type=@int
+type IntStack struct {
+	stack
+	data []int
+}
+
 type Message struct {
 	Sender string
 	Body   string
 }
 
 type MessageStack struct {
-	stackGen `codegen:"type=Message"`
+	stackGen `codegen:"type=@Message"`
 	stack
 	data []Message
 }
@@ -40,6 +49,14 @@ func main() {
 	sstack.Pop()
 	fmt.Println(sstack.Peek())
 
+	istack := &IntStack{}
+	istack.Push(1)
+	istack.Push(2)
+
+	fmt.Println(istack.Peek())
+	istack.Pop()
+	fmt.Println(istack.Peek())
+
 	mstack := &MessageStack{}
 	mstack.Push(Message{Sender: "me", Body: "Hello"})
 	mstack.Push(Message{Sender: "you", Body: "Goodbye"})
@@ -2,6 +2,30 @@
 
 package main
 
+func (stack *IntStack) Push(val int) {
+	stack.data = append(stack.data, val)
+	stack.top++
+}
+
+func (stack *IntStack) Pop() error {
+	if stack.top == 0 {
+		return ErrEmptyStack
+	}
+
+	stack.top--
+	return nil
+}
+
+func (stack *IntStack) Peek() (result int, err error) {
+	if stack.top <= 0 {
+		err = ErrEmptyStack
+		return
+	}
+
+	result = stack.data[stack.top-1]
+	return
+}
+
 func (stack *MessageStack) Push(val Message) {
 	stack.data = append(stack.data, val)
 	stack.top++
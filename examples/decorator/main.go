@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+//go:generate go-codegen $GOFILE
+
+// loggingGen is a template that generates a decorator for an interface, which
+// logs every method call before passing it on.
+type loggingGen struct{}
+
+// Store is a simple key value store.
+//
+//codegen:loggingGen
+type Store interface {
+	io.Closer
+	Get(key string) (string, bool)
+	Set(key, value string)
+	Delete(keys ...string) int
+}
+
+type mapStore map[string]string
+
+func (s mapStore) Close() error { return nil }
+
+func (s mapStore) Get(key string) (string, bool) {
+	v, ok := s[key]
+	return v, ok
+}
+
+func (s mapStore) Set(key, value string) { s[key] = value }
+
+func (s mapStore) Delete(keys ...string) int {
+	for _, k := range keys {
+		delete(s, k)
+	}
+	return len(keys)
+}
+
+func main() {
+	var out strings.Builder
+	var s Store = LoggingStore{Next: mapStore{}, Log: &out}
+	s.Set("hello", "world")
+	fmt.Println(s.Get("hello"))
+	s.Delete("hello", "goodbye")
+	s.Close()
+	fmt.Print(out.String())
+}
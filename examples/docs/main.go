@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+//go:generate go-codegen $GOFILE
+
+// usageGen is a template that generates a Usage method describing a struct
+// from its doc comments.
+type usageGen struct{}
+
+// Options configures the server.
+type Options struct {
+	usageGen `codegen:""`
+
+	// Host is the address to listen on.
+	Host string
+	Port int // The port to listen on.
+	// Verbose enables debug logging.
+	Verbose bool
+}
+
+func main() {
+	fmt.Print(Options{}.Usage())
+}
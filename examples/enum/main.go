@@ -0,0 +1,32 @@
+package main
+
+import "fmt"
+
+//go:generate go-codegen $GOFILE
+
+// enumGen is a template that generates `String` and `Parse` functions for an
+// enum-like type from the constants declared with that type.
+type enumGen struct{}
+
+// Status is the state of a job.
+//
+//codegen:enumGen
+type Status int
+
+const (
+	// Pending jobs have not been started yet.
+	Pending Status = iota
+	// Running jobs have been started but not finished.
+	Running
+	// Done jobs have finished.
+	Done
+)
+
+func main() {
+	fmt.Println(Pending, Running, Done)
+
+	s, err := ParseStatus("Running")
+	fmt.Println(int(s), err)
+	_, err = ParseStatus("Unknown")
+	fmt.Println(err)
+}
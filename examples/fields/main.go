@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//go:generate go-codegen $GOFILE
+
+// rowGen is a template that generates methods mapping a struct onto a database
+// row, using the `db` tags of its fields.
+type rowGen struct{}
+
+type Timestamps struct {
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at,omitempty"`
+}
+
+type User struct {
+	rowGen `codegen:""`
+	Timestamps
+
+	ID    int    `db:"id"`
+	Name  string `db:"name"`
+	Email string `db:"email,omitempty" json:"email"`
+	// Password is never stored as is.
+	Password string `db:"-"`
+	notes    string
+}
+
+func main() {
+	u := User{ID: 1, Name: "Ada"}
+	fmt.Println(u.Columns())
+	fmt.Println(u.Values()[:3]...)
+}
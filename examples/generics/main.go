@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//go:generate go-codegen $GOFILE
+
+// setGen is a generic template that generates methods for a set of T, held in
+// the items field.
+type setGen[T comparable] struct{}
+
+// listGen is a template that generates methods for a list, held in the items
+// field of a generic type.
+type listGen struct{}
+
+type Tags struct {
+	setGen[string] `codegen:""`
+	items          map[string]struct{}
+}
+
+// Timeouts invokes a generic template with a directive instead of a tagged
+// field.
+//
+//codegen:setGen[time.Duration]
+type Timeouts struct {
+	items map[time.Duration]struct{}
+}
+
+type List[T any] struct {
+	listGen `codegen:""`
+	items   []T
+}
+
+func main() {
+	tags := &Tags{}
+	tags.Add("red")
+	tags.Add("blue")
+	tags.Add("red")
+	fmt.Println(tags.Len(), tags.Has("red"), tags.Has("green"))
+
+	timeouts := &Timeouts{}
+	timeouts.Add(time.Second)
+	fmt.Println(timeouts.Has(time.Second), timeouts.Has(time.Minute))
+
+	list := NewList(1, 2)
+	list.Push(3)
+	fmt.Println(list.Len(), list.At(2))
+}
@@ -0,0 +1,55 @@
+// Code generated by go-codegen; DO NOT EDIT.
+
+package main
+
+import (
+	"time"
+)
+
+func NewList[T any](items ...T) *List[T] {
+	return &List[T]{items: items}
+}
+
+func (l *List[T]) Push(v T) {
+	l.items = append(l.items, v)
+}
+
+func (l *List[T]) At(i int) T {
+	return l.items[i]
+}
+
+func (l *List[T]) Len() int {
+	return len(l.items)
+}
+
+func (s *Tags) Add(v string) {
+	if s.items == nil {
+		s.items = make(map[string]struct{})
+	}
+	s.items[v] = struct{}{}
+}
+
+func (s *Tags) Has(v string) bool {
+	_, ok := s.items[v]
+	return ok
+}
+
+func (s *Tags) Len() int {
+	return len(s.items)
+}
+
+func (s *Timeouts) Add(v time.Duration) {
+	if s.items == nil {
+		s.items = make(map[time.Duration]struct{})
+	}
+	s.items[v] = struct{}{}
+}
+
+func (s *Timeouts) Has(v time.Duration) bool {
+	_, ok := s.items[v]
+	return ok
+}
+
+func (s *Timeouts) Len() int {
+	return len(s.items)
+}
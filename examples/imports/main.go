@@ -0,0 +1,22 @@
+package main
+
+//go:generate go-codegen $GOFILE
+
+// dumpGen is a template that imports every package its branches might need,
+// leaving it to go-codegen to remove those that end up unused.
+type dumpGen struct{}
+
+//codegen:dumpGen
+type Point struct {
+	X, Y int
+}
+
+//codegen:dumpGen stderr
+type Size struct {
+	Width, Height int
+}
+
+func main() {
+	Point{X: 1, Y: 2}.Dump()
+	Size{Width: 3, Height: 4}.Dump()
+}
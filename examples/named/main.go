@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+//go:generate go-codegen $GOFILE
+
+// unitGen is a template that can be invoked on any named type, not just
+// structs, by using a comment directive.
+type unitGen struct{}
+
+//codegen:unitGen unit=km
+type Kilometers float64
+
+//codegen:unitGen unit=kg
+type Kilograms int
+
+func main() {
+	fmt.Println(Kilometers(42.195))
+	fmt.Println(Kilograms(70))
+}
@@ -33,6 +33,12 @@ func (cmd *GoodbyeCommand) Execute() (interface{}, error) {
 	return "Goodbye, " + cmd.Name, nil
 }
 
+// MustExecute is written by hand, so the cmd template doesn't generate it.
+func (cmd *GoodbyeCommand) MustExecute() interface{} {
+	result, _ := cmd.Execute()
+	return result.(string) + "!"
+}
+
 func main() {
 	var c cmd
 	c = &HelloCommand{Name: "You"}
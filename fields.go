@@ -0,0 +1,178 @@
+package codegen
+
+import (
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Field describes a field of a struct for use in templates.
+type Field struct {
+	Name string
+	Type types.Type
+	// Tag is the field's whole tag, e.g. `db:"name" json:"name,omitempty"`.
+	Tag reflect.StructTag
+	// Tags maps each key of `Tag` onto its value.
+	Tags map[string]string
+	// Embedded is true for an embedded field, whose name is that of its type.
+	Embedded bool
+	// Exported is true if the field's name is exported.
+	Exported bool
+	// Promoted is true for a field of an embedded struct, which is accessed
+	// through the struct as if it was its own.
+	Promoted bool
+	// Index is the sequence of field indices to reach the field from the
+	// struct, with more than one for a promoted field, like
+	// `reflect.StructField.Index`.
+	Index []int
+	// Object is the field itself, for anything not covered above.
+	Object *types.Var
+}
+
+// Fields returns the fields of the struct type the template is invoked on in
+// declaration order, with the fields promoted from each embedded struct
+// following it.  Promoted fields that can't be accessed directly, because they
+// are shadowed, ambiguous or unexported in another package, are left out.
+func (c *TemplateContext) Fields() ([]Field, error) {
+	s, ok := c.Underlying.(*types.Struct)
+	if !ok {
+		return nil, errors.Errorf("%s is not a struct", c.StructName)
+	}
+	fields := structFieldList(s, nil, map[*types.Named]bool{c.Struct: true})
+
+	// Resolve promoted fields like a selector would, so that only accessible
+	// fields are kept.
+	pkg := c.Struct.Obj().Pkg()
+	accessible := fields[:0]
+	for _, f := range fields {
+		if f.Promoted {
+			obj, index, _ := types.LookupFieldOrMethod(c.Struct, false, pkg, f.Name)
+			if obj != f.Object || len(index) != len(f.Index) {
+				continue
+			}
+		}
+		accessible = append(accessible, f)
+	}
+	return accessible, nil
+}
+
+// structFieldList returns the fields of `s`, followed by the fields promoted
+// from each embedded struct, with `index` prepended to their indices.  `seen`
+// holds the named types already being listed, to stop at embedding cycles.
+func structFieldList(s *types.Struct, index []int, seen map[*types.Named]bool) []Field {
+	var fields []Field
+	for i := 0; i < s.NumFields(); i++ {
+		v := s.Field(i)
+		tag := reflect.StructTag(s.Tag(i))
+		fieldIndex := append(append([]int(nil), index...), i)
+		fields = append(fields, Field{
+			Name:     v.Name(),
+			Type:     v.Type(),
+			Tag:      tag,
+			Tags:     parseStructTag(tag),
+			Embedded: v.Embedded(),
+			Exported: v.Exported(),
+			Promoted: len(index) > 0,
+			Index:    fieldIndex,
+			Object:   v,
+		})
+
+		if !v.Embedded() {
+			continue
+		}
+		t := v.Type()
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		named, _ := t.(*types.Named)
+		embedded, ok := t.Underlying().(*types.Struct)
+		if !ok || (named != nil && seen[named]) {
+			continue
+		}
+		if named != nil {
+			seen[named] = true
+		}
+		fields = append(fields, structFieldList(embedded, fieldIndex, seen)...)
+		if named != nil {
+			delete(seen, named)
+		}
+	}
+	return fields
+}
+
+// parseStructTag maps each key of `tag` onto its value, following the
+// conventional format parsed by `reflect.StructTag.Get`.  Parsing stops at the
+// first malformed pair.
+func parseStructTag(tag reflect.StructTag) map[string]string {
+	tags := make(map[string]string)
+	s := string(tag)
+	for {
+		s = strings.TrimLeft(s, " ")
+		i := strings.Index(s, `:"`)
+		if i <= 0 || strings.ContainsAny(s[:i], " \"") {
+			return tags
+		}
+		key := s[:i]
+		s = s[i+1:]
+
+		// Find the closing quote, skipping escaped ones.
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return tags
+		}
+		value, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return tags
+		}
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+		s = s[end+1:]
+	}
+}
+
+// fieldTag returns the value of the tag `key` of `field`, which is a `Field` or
+// a tag string, e.g. `{{ fieldTag $field "db" }}`.  It returns an empty string
+// if the field has no such tag.
+func fieldTag(field interface{}, key string) (string, error) {
+	tag, err := tagOf(field)
+	if err != nil {
+		return "", err
+	}
+	return tag.Get(key), nil
+}
+
+// fieldTagOptions returns the comma separated options following the name in
+// the value of the tag `key` of `field`, e.g. `[omitempty]` for
+// `json:"name,omitempty"`.
+func fieldTagOptions(field interface{}, key string) ([]string, error) {
+	value, err := fieldTag(field, key)
+	if err != nil {
+		return nil, err
+	}
+	options := strings.Split(value, ",")
+	return options[1:], nil
+}
+
+func tagOf(field interface{}) (reflect.StructTag, error) {
+	switch f := field.(type) {
+	case Field:
+		return f.Tag, nil
+	case *Field:
+		return f.Tag, nil
+	case reflect.StructTag:
+		return f, nil
+	case string:
+		return reflect.StructTag(f), nil
+	}
+	return "", errors.Errorf("expected a field or tag, not %T", field)
+}
@@ -0,0 +1,222 @@
+package codegen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// FrontMatter holds the metadata a template may declare in a YAML block at its
+// very start, delimited by lines holding only `---`:
+//
+//	---
+//	description: A stack of values of any type.
+//	args:
+//	  type:
+//	    description: The type of the values.
+//	    required: true
+//	  order:
+//	    default: lifo
+//	    allowed: [lifo, fifo]
+//	imports: [errors]
+//	---
+//	func (s *{{ .StructName }}) Push(v {{ .Arg "type" }}) {
+type FrontMatter struct {
+	Description string `yaml:"description"`
+	// Args declares the arguments the template accepts.  If it's set, even to
+	// an empty map, invocations passing any other argument are rejected.
+	Args map[string]ArgSpec `yaml:"args"`
+	// Imports lists the paths of packages the generated code always uses.
+	Imports []string `yaml:"imports"`
+}
+
+// ArgSpec declares an argument accepted by a template.
+type ArgSpec struct {
+	Description string `yaml:"description"`
+	// Required rejects invocations that don't pass the argument.
+	Required bool `yaml:"required"`
+	// Default is the value the argument takes when it isn't passed.
+	Default *string `yaml:"default"`
+	// Allowed, if set, lists the only values the argument may take.
+	Allowed []string `yaml:"allowed"`
+	// Type resolves the argument as a go type, e.g. `Message` or
+	// `[]time.Duration`, which may be written with a leading `@`.  See
+	// `$.ArgType`.
+	Type bool `yaml:"type"`
+}
+
+const frontMatterDelim = "---"
+
+// ReadFrontMatter returns the front matter declared by the template file at
+// `path`, or nil if it has none.
+func ReadFrontMatter(path string) (*FrontMatter, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	frontMatter, _, ok := splitFrontMatter(content)
+	if !ok {
+		return nil, nil
+	}
+	return parseFrontMatter(frontMatter)
+}
+
+// splitFrontMatter separates the front matter from the rest of `content`, and
+// returns them along with whether there is any.  The front matter is replaced
+// by a template comment spanning the same lines, so positions within the
+// template are unaffected.
+func splitFrontMatter(content []byte) (frontMatter, body []byte, ok bool) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines) == 0 || string(bytes.TrimSpace(lines[0])) != frontMatterDelim {
+		return nil, content, false
+	}
+	for i := 1; i < len(lines); i++ {
+		if string(bytes.TrimSpace(lines[i])) != frontMatterDelim {
+			continue
+		}
+		frontMatter = bytes.Join(lines[1:i], nil)
+		var replaced bytes.Buffer
+		replaced.WriteString("{{/*")
+		replaced.WriteString(strings.Repeat("\n", i+1))
+		replaced.WriteString("*/}}")
+		replaced.Write(bytes.Join(lines[i+1:], nil))
+		return frontMatter, replaced.Bytes(), true
+	}
+	return nil, content, false
+}
+
+func parseFrontMatter(content []byte) (*FrontMatter, error) {
+	var fm FrontMatter
+	if err := yaml.UnmarshalStrict(content, &fm); err != nil {
+		return nil, errors.Wrap(err, "parsing front matter")
+	}
+	return &fm, nil
+}
+
+// apply validates `args` against the front matter, returning them with the
+// defaults of any that weren't passed filled in.  The args named in
+// `inherited` were passed down from an outer invocation, rather than to the
+// template itself, so they are dropped instead of rejected if the template
+// doesn't declare them.
+func (fm *FrontMatter) apply(args map[string]string, inherited map[string]bool) (map[string]string, error) {
+	if fm.Args == nil {
+		return args, nil
+	}
+
+	applied := make(map[string]string, len(fm.Args))
+	for name, value := range args {
+		spec, ok := fm.Args[name]
+		if !ok && inherited[name] {
+			continue
+		} else if !ok {
+			return nil, fm.unknownArgError(name)
+		}
+		if len(spec.Allowed) > 0 && !containsString(spec.Allowed, value) {
+			return nil, errors.Errorf(
+				"argument %s must be one of %s, not %q",
+				name,
+				strings.Join(spec.Allowed, ", "),
+				value,
+			)
+		}
+		applied[name] = value
+	}
+	for _, name := range fm.argNames() {
+		spec := fm.Args[name]
+		if _, ok := applied[name]; ok {
+			continue
+		}
+		if spec.Required {
+			return nil, errors.Errorf("missing required argument %s", name)
+		}
+		if spec.Default != nil {
+			applied[name] = *spec.Default
+		}
+	}
+	return applied, nil
+}
+
+func (fm *FrontMatter) unknownArgError(name string) error {
+	names := fm.argNames()
+	if len(names) == 0 {
+		return errors.Errorf("unknown argument %s, the template accepts none", name)
+	}
+	// Suggest the closest accepted name, as unknown arguments are usually typos.
+	closest, closestDistance := "", len(name)/2+1
+	for _, accepted := range names {
+		if d := editDistance(name, accepted); d < closestDistance {
+			closest, closestDistance = accepted, d
+		}
+	}
+	if closest != "" {
+		return errors.Errorf("unknown argument %s, did you mean %s?", name, closest)
+	}
+	return errors.Errorf(
+		"unknown argument %s, the template accepts %s",
+		name,
+		strings.Join(names, ", "),
+	)
+}
+
+// typeArgNames returns the names of the arguments declared as types.
+func (fm *FrontMatter) typeArgNames() []string {
+	var names []string
+	for _, name := range fm.argNames() {
+		if fm.Args[name].Type {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (fm *FrontMatter) argNames() []string {
+	names := make([]string, 0, len(fm.Args))
+	for name := range fm.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsString(ss []string, s string) bool {
+	for _, candidate := range ss {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the Levenshtein distance between `a` and `b`.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, i := range rest {
+		if i < first {
+			first = i
+		}
+	}
+	return first
+}
@@ -1,10 +1,26 @@
 module github.com/CyborgMaster/go-codegen
 
-go 1.14
+go 1.22.0
 
 require (
 	github.com/Masterminds/sprig/v3 v3.2.2
 	github.com/jinzhu/inflection v1.0.0
 	github.com/pkg/errors v0.9.1
-	golang.org/x/tools v0.1.0
+	golang.org/x/tools v0.30.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/Masterminds/goutils v1.1.1 // indirect
+	github.com/Masterminds/semver/v3 v3.1.1 // indirect
+	github.com/google/uuid v1.1.1 // indirect
+	github.com/huandu/xstrings v1.3.1 // indirect
+	github.com/imdario/mergo v0.3.11 // indirect
+	github.com/mitchellh/copystructure v1.0.0 // indirect
+	github.com/mitchellh/reflectwalk v1.0.0 // indirect
+	github.com/shopspring/decimal v1.2.0 // indirect
+	github.com/spf13/cast v1.3.1 // indirect
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
+	golang.org/x/mod v0.23.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
 )
@@ -0,0 +1,140 @@
+package codegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// importNamer is implemented by `TypeInfo`s that assign unique names to
+// imports, such as `GenContext`.
+type importNamer interface {
+	ImportName(pkg string) string
+}
+
+// uniqueImportName chooses the name the package `pkg` is imported as, which
+// must not collide with another import or a package level declaration.
+func (ctx *GenContext) uniqueImportName(pkg string) string {
+	name := assumedPackageName(pkg)
+	if p, ok := ctx.packages[pkg]; ok {
+		name = p.Name()
+	}
+	if name == "" {
+		name = "pkg"
+	}
+	if !ctx.importNameTaken(name) {
+		return name
+	}
+
+	// Prefer qualifying the name with its parent directory, e.g.
+	// `github.com/pkg/errors` becomes `pkgerrors`, before resorting to a number.
+	if parent := identifierPrefix(path.Base(path.Dir(pkg))); parent != "" {
+		if qualified := parent + name; !ctx.importNameTaken(qualified) {
+			return qualified
+		}
+	}
+	for i := 2; ; i++ {
+		if numbered := fmt.Sprint(name, i); !ctx.importNameTaken(numbered) {
+			return numbered
+		}
+	}
+}
+
+func (ctx *GenContext) importNameTaken(name string) bool {
+	if _, taken := ctx.importPaths[name]; taken {
+		return true
+	}
+	return ctx.rootScope != nil && ctx.rootScope.Lookup(name) != nil
+}
+
+// assumedPackageName returns the name a package is assumed to have from its
+// import path, following the same rules as goimports: the last element of the
+// path, skipping major version suffixes, without a `go-` prefix and cut at the
+// first character that isn't valid in an identifier.  A package whose name
+// differs from this must be imported with an explicit name.
+func assumedPackageName(pkg string) string {
+	base := path.Base(pkg)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := path.Dir(pkg); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	return identifierPrefix(base)
+}
+
+// identifierPrefix returns the longest prefix of `s` that is a valid
+// identifier.
+func identifierPrefix(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if i >= 0 {
+		s = s[:i]
+	}
+	if s != "" && unicode.IsDigit(rune(s[0])) {
+		return ""
+	}
+	return s
+}
+
+// pruneImports removes the imports from `file` that it doesn't use, as
+// templates often add imports that end up unused in some branches.  `names`
+// maps the path of each import onto the name it is referred to by.
+func pruneImports(fset *token.FileSet, file *ast.File, names map[string]string) {
+	used := usedPackageNames(file)
+	// Deleting an import removes it from `file.Imports`, so loop over a copy.
+	for _, spec := range append([]*ast.ImportSpec(nil), file.Imports...) {
+		name := importSpecName(spec, names)
+		if name == "_" || name == "." || used[name] {
+			continue
+		}
+		specName := ""
+		if spec.Name != nil {
+			specName = spec.Name.Name
+		}
+		pkg, _ := strconv.Unquote(spec.Path.Value)
+		astutil.DeleteNamedImport(fset, file, specName, pkg)
+	}
+}
+
+// importSpecName returns the name the package imported by `spec` is referred
+// to by.  `names` maps the path of each import added by a `GenContext` onto its
+// name.
+func importSpecName(spec *ast.ImportSpec, names map[string]string) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	pkg, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+	if name, ok := names[pkg]; ok {
+		return name
+	}
+	return assumedPackageName(pkg)
+}
+
+// usedPackageNames returns the names that `file` selects from but doesn't
+// declare itself, such as `fmt` in `fmt.Println`.  These are either imported
+// packages or declared elsewhere in the package.
+func usedPackageNames(file *ast.File) map[string]bool {
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+				used[x.Name] = true
+			}
+		}
+		return true
+	})
+	return used
+}
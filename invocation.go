@@ -2,9 +2,7 @@ package codegen
 
 import (
 	"go/types"
-	"net/url"
 	"reflect"
-	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -12,6 +10,10 @@ import (
 type Invocation struct {
 	GenType *types.Named
 	Args    map[string]string
+
+	// inherited holds the names of the args passed down from an outer
+	// invocation, which templates that don't declare them ignore.
+	inherited map[string]bool
 }
 
 func InvocationsForStruct(aStruct *types.Struct) ([]Invocation, error) {
@@ -33,51 +35,43 @@ func InvocationsForStruct(aStruct *types.Struct) ([]Invocation, error) {
 			return nil, errors.Wrap(err, "parsing codgen tag for args")
 		}
 
-		invocations = append(invocations, Invocation{
-			GenType: genType,
-			Args:    args,
-		})
-
-		// If the codegen field is itself a struct, then recurse.
-		if structType, ok := genType.Underlying().(*types.Struct); ok {
-			nested, err := InvocationsForStruct(structType)
-			if err != nil {
-				return nil, errors.Wrap(err, "nested "+genType.Obj().Name())
-			}
-			// Pass any args defined by the outer invocation that aren't defined by
-			// the inner invocation down.
-			for arg, v := range args {
-				for _, n := range nested {
-					if _, inner := n.Args[arg]; !inner {
-						n.Args[arg] = v
-					}
-				}
-			}
-			invocations = append(invocations, nested...)
+		withNested, err := invocationWithNested(genType, args)
+		if err != nil {
+			return nil, err
 		}
+		invocations = append(invocations, withNested...)
 	}
 
 	return invocations, nil
 }
 
-func parseArgs(tag string) (map[string]string, error) {
-	// The format of the tag of `foo=bar,baz=quux` is almost identical to a query
-	// string so we picky back on this implementation, first replacing the commas
-	// with '&' to be compatible with the query string.
-	values, err := url.ParseQuery(strings.ReplaceAll(tag, ",", "&"))
-	if err != nil {
-		return nil, err
-	}
+// invocationWithNested returns the invocation of `genType` with `args`,
+// followed by the invocations nested within it.
+func invocationWithNested(genType *types.Named, args map[string]string) ([]Invocation, error) {
+	invocations := []Invocation{{GenType: genType, Args: args}}
 
-	// If an arg is specified more than once, the first occurrence wins.
-	args := make(map[string]string, len(values))
-	for arg, vals := range values {
-		val := ""
-		if len(vals) > 0 {
-			val = vals[0]
+	// If the gen type is itself a struct, then recurse.
+	if structType, ok := genType.Underlying().(*types.Struct); ok {
+		nested, err := InvocationsForStruct(structType)
+		if err != nil {
+			return nil, errors.Wrap(err, "nested "+genType.Obj().Name())
 		}
-		args[arg] = val
+		// Pass any args defined by the outer invocation that aren't defined by
+		// the inner invocation down.
+		for arg, v := range args {
+			for i := range nested {
+				n := &nested[i]
+				if _, inner := n.Args[arg]; !inner {
+					n.Args[arg] = v
+					if n.inherited == nil {
+						n.inherited = make(map[string]bool)
+					}
+					n.inherited[arg] = true
+				}
+			}
+		}
+		invocations = append(invocations, nested...)
 	}
 
-	return args, nil
+	return invocations, nil
 }
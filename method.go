@@ -0,0 +1,217 @@
+package codegen
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Method describes a method for use in templates, with its signature already
+// rendered through `$.TypeString`.
+type Method struct {
+	Name    string
+	Params  []Param
+	Results []Param
+	// Variadic is true if the last parameter is variadic.
+	Variadic bool
+	// ParamList is the parameter list as it appears in a declaration, without
+	// the parenthesis, e.g. `ctx context.Context, keys ...string`.
+	ParamList string
+	// CallArgs passes the parameters on to a call, without the parenthesis,
+	// e.g. `ctx, keys...`.
+	CallArgs string
+	// ResultList is the result list as it appears in a declaration, e.g. ``,
+	// `error` or `(int, error)`.
+	ResultList string
+	// Signature is the method as it appears in an interface, e.g. `Get(key
+	// string) (string, bool)`.
+	Signature string
+	// PointerReceiver is true for a method that can only be called on a
+	// pointer, as listed by `$.Methods`.
+	PointerReceiver bool
+	// Promoted is true for a method promoted from an embedded field, as listed
+	// by `$.Methods`.
+	Promoted bool
+	// Object is the method itself, for anything not covered above.
+	Object *types.Func
+}
+
+// Param describes a parameter or result of a `Method`.
+type Param struct {
+	// Name is the name the parameter was declared with.  Parameters that are
+	// unnamed or named `_` are given the name `p` followed by their index, or
+	// the next free number if another parameter or result already has that
+	// name, so they can be referenced.  Results keep their declared name, if
+	// any.
+	Name string
+	Type types.Type
+	// TypeString is the type rendered through `$.TypeString`, with a leading
+	// `...` instead of `[]` for a variadic parameter.
+	TypeString string
+	Variadic   bool
+}
+
+// InterfaceMethods returns the full method set of the interface type `t`,
+// including methods from embedded interfaces, sorted by name.  The packages
+// of all parameter and result types are imported.
+func (c *TemplateContext) InterfaceMethods(t types.Type) ([]Method, error) {
+	iface, ok := t.Underlying().(*types.Interface)
+	if !ok {
+		return nil, errors.Errorf("%s is not an interface", t)
+	}
+
+	methods := make([]Method, iface.NumMethods())
+	for i := range methods {
+		m, err := c.newMethod(iface.Method(i))
+		if err != nil {
+			return nil, err
+		}
+		methods[i] = m
+	}
+	return methods, nil
+}
+
+// Methods returns the methods that can be called on the type the template is
+// invoked on, or on a pointer to it, sorted by name.  This includes methods
+// promoted from embedded fields, but not those declared in the files being
+// generated, as they are about to be replaced.  The packages of all parameter
+// and result types are imported.
+func (c *TemplateContext) Methods() ([]Method, error) {
+	var methods []Method
+	for _, sel := range c.methodSelections() {
+		m, err := c.newMethod(sel.Obj().(*types.Func))
+		if err != nil {
+			return nil, err
+		}
+		m.Promoted = len(sel.Index()) > 1
+		if sig := sel.Obj().Type().(*types.Signature); sig.Recv() != nil {
+			_, m.PointerReceiver = sig.Recv().Type().(*types.Pointer)
+		}
+		methods = append(methods, m)
+	}
+	return methods, nil
+}
+
+// HasMethod returns true if a method `name` can be called on the type the
+// template is invoked on, or on a pointer to it, like `$.Methods`.  Use it to
+// avoid generating a method that has been written by hand.
+func (c *TemplateContext) HasMethod(name string) bool {
+	for _, sel := range c.methodSelections() {
+		if sel.Obj().Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MethodSignature returns the signature of the method `name` of the type the
+// template is invoked on, like `Method.Signature`, e.g. `Execute() (interface{},
+// error)`.  Templates can compare it with the signature they would generate to
+// fail with a clear error on a conflict.
+func (c *TemplateContext) MethodSignature(name string) (string, error) {
+	for _, sel := range c.methodSelections() {
+		if sel.Obj().Name() == name {
+			m, err := c.newMethod(sel.Obj().(*types.Func))
+			return m.Signature, err
+		}
+	}
+	return "", errors.Errorf("%s has no method %s", c.StructName, name)
+}
+
+// methodSelections returns the method set of a pointer to the type the template
+// is invoked on, or of the type itself for an interface, without the methods
+// declared in the files being generated.
+func (c *TemplateContext) methodSelections() []*types.Selection {
+	var t types.Type = c.Struct
+	if !types.IsInterface(t) {
+		t = types.NewPointer(t)
+	}
+	generated, _ := c.info.(outputInfo)
+
+	set := types.NewMethodSet(t)
+	var sels []*types.Selection
+	for i := 0; i < set.Len(); i++ {
+		sel := set.At(i)
+		if generated != nil && generated.inOutput(sel.Obj()) {
+			continue
+		}
+		sels = append(sels, sel)
+	}
+	return sels
+}
+
+// outputInfo is implemented by `TypeInfo`s that know which files are being
+// generated, such as `GenContext`.
+type outputInfo interface {
+	inOutput(obj types.Object) bool
+}
+
+func (c *TemplateContext) newMethod(fn *types.Func) (Method, error) {
+	sig := fn.Type().(*types.Signature)
+	if _, err := c.AddImportType(sig); err != nil {
+		return Method{}, errors.Wrapf(err, "importing types of method %s", fn.Name())
+	}
+
+	m := Method{
+		Name:     fn.Name(),
+		Variadic: sig.Variadic(),
+		Object:   fn,
+	}
+
+	// Unnamed parameters are given names, which mustn't clash with the names
+	// declared in the signature.
+	used := make(map[string]bool)
+	for _, tuple := range []*types.Tuple{sig.Params(), sig.Results()} {
+		for i := 0; i < tuple.Len(); i++ {
+			used[tuple.At(i).Name()] = true
+		}
+	}
+
+	var params, args []string
+	for i := 0; i < sig.Params().Len(); i++ {
+		v := sig.Params().At(i)
+		p := Param{
+			Name:       v.Name(),
+			Type:       v.Type(),
+			TypeString: c.TypeString(v.Type()),
+			Variadic:   sig.Variadic() && i == sig.Params().Len()-1,
+		}
+		if p.Name == "" || p.Name == "_" {
+			for n := i; p.Name == "" || p.Name == "_" || used[p.Name]; n++ {
+				p.Name = fmt.Sprintf("p%d", n)
+			}
+			used[p.Name] = true
+		}
+		arg := p.Name
+		if p.Variadic {
+			p.TypeString = "..." + c.TypeString(v.Type().(*types.Slice).Elem())
+			arg += "..."
+		}
+		m.Params = append(m.Params, p)
+		params = append(params, p.Name+" "+p.TypeString)
+		args = append(args, arg)
+	}
+	m.ParamList = strings.Join(params, ", ")
+	m.CallArgs = strings.Join(args, ", ")
+
+	var results []string
+	for i := 0; i < sig.Results().Len(); i++ {
+		v := sig.Results().At(i)
+		r := Param{
+			Name:       v.Name(),
+			Type:       v.Type(),
+			TypeString: c.TypeString(v.Type()),
+		}
+		m.Results = append(m.Results, r)
+		results = append(results, r.TypeString)
+	}
+	m.ResultList = strings.Join(results, ", ")
+	if len(results) > 1 {
+		m.ResultList = "(" + m.ResultList + ")"
+	}
+	m.Signature = strings.TrimSpace(fmt.Sprintf("%s(%s) %s", m.Name, m.ParamList, m.ResultList))
+
+	return m, nil
+}
@@ -3,25 +3,81 @@ package codegen
 import (
 	"bytes"
 	"fmt"
+	"go/format"
 	"go/parser"
-	"go/printer"
+	"go/scanner"
 	"go/token"
 	"io"
 	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
 func Output(ctx *GenContext, filePath string) error {
+	formatted, err := Render(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, formatted, 0644)
+}
+
+// Check renders the generated code exactly as `Output` would, but instead of
+// writing it, compares it with the file already at `filePath`.  It returns a
+// unified diff from the existing file to the generated code, which is empty if
+// the file is up to date.
+func Check(ctx *GenContext, filePath string) (string, error) {
+	formatted, err := Render(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	return checkGenerated(filePath, formatted)
+}
+
+func checkGenerated(filePath string, formatted []byte) (string, error) {
+	existing, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "reading existing generated code")
+	}
+	return unifiedDiff(filePath, filePath+" (generated)", existing, formatted), nil
+}
+
+// Print renders the generated code exactly as `Output` would, but writes it to
+// `w`, preceded by a header naming `filePath`, instead of to disk.
+func Print(ctx *GenContext, filePath string, w io.Writer) error {
+	formatted, err := Render(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	return printGenerated(w, filePath, formatted)
+}
+
+func printGenerated(w io.Writer, filePath string, formatted []byte) error {
+	if _, err := fmt.Fprintf(w, "==> %s <==\n", filePath); err != nil {
+		return err
+	}
+	_, err := w.Write(formatted)
+	return err
+}
+
+// Render returns the formatted contents of the go file that `Output` would write
+// to `filePath`, without touching the disk.
+func Render(ctx *GenContext, filePath string) ([]byte, error) {
 	if ctx.PackageName == "" {
-		return errors.New("missing package name")
+		return nil, errors.New("missing package name")
 	}
 
 	var unformatted bytes.Buffer
 	fmt.Fprint(&unformatted, "// Code generated by go-codegen; DO NOT EDIT.\n\n")
 	fmt.Fprintf(&unformatted, "package %s\n", ctx.PackageName)
-	outputImports(ctx.Imports(), &unformatted)
-	for _, g := range ctx.Generated() {
+	outputImports(ctx, &unformatted)
+	// Record the line each chunk of generated code starts on, so errors can be
+	// traced back to the template that generated them.
+	startLines := make([]int, len(ctx.generated))
+	for i, g := range ctx.generated {
+		startLines[i] = bytes.Count(unformatted.Bytes(), []byte("\n")) + 1
 		fmt.Fprintln(&unformatted, g)
 	}
 
@@ -29,22 +85,149 @@ func Output(ctx *GenContext, filePath string) error {
 	fset := token.NewFileSet()
 	file, err := parser.ParseFile(fset, filePath, unformatted.Bytes(), parser.ParseComments)
 	if err != nil {
-		fmt.Println(unformatted.String())
-		return errors.Wrap(err, "parsing generated code")
+		return nil, newGeneratedCodeError(ctx, filePath, unformatted.Bytes(), startLines, err)
+	}
+	if err := checkDuplicateDeclarations(ctx, filePath, fset, file, startLines); err != nil {
+		return nil, err
+	}
+	if ctx.LineDirectives {
+		source := addLineDirectives(ctx, filePath, unformatted.Bytes(), startLines)
+		fset = token.NewFileSet()
+		if file, err = parser.ParseFile(fset, filePath, source, parser.ParseComments); err != nil {
+			return nil, errors.Wrap(err, "parsing generated code with line directives")
+		}
+	}
+
+	pruneImports(fset, file, ctx.importNames)
+	if ctx.AddMissingImports {
+		if err := addMissingImports(fset, file, ctx.rootScope, ctx.importNames); err != nil {
+			return nil, errors.Wrap(err, "adding missing imports")
+		}
 	}
 
 	var formatted bytes.Buffer
-	printer.Fprint(&formatted, fset, file)
-	return ioutil.WriteFile(filePath, formatted.Bytes(), 0644)
+	if err := format.Node(&formatted, fset, file); err != nil {
+		return nil, errors.Wrap(err, "formatting generated code")
+	}
+	if ctx.LineDirectives {
+		compacted, err := compactLineDirectives(formatted.Bytes())
+		return compacted, errors.Wrap(err, "formatting generated code with line directives")
+	}
+	return formatted.Bytes(), nil
 }
 
-func outputImports(imports []string, w io.Writer) {
+func outputImports(ctx *GenContext, w io.Writer) {
+	imports := ctx.Imports()
 	if len(imports) == 0 {
 		return
 	}
 	fmt.Fprintln(w, "import (")
 	for _, i := range imports {
-		fmt.Fprintf(w, "\t\"%s\"\n", i)
+		// Only name the import explicitly when it can't be inferred from the
+		// path.
+		if name := ctx.ImportName(i); name != assumedPackageName(i) {
+			fmt.Fprintf(w, "\t%s \"%s\"\n", name, i)
+		} else {
+			fmt.Fprintf(w, "\t\"%s\"\n", i)
+		}
 	}
 	fmt.Fprintln(w, ")")
 }
+
+// GeneratedCodeError is returned by `Render` when templates generate code that
+// isn't valid go.  It traces the first syntax error back to the template
+// invocation that generated the offending line.
+type GeneratedCodeError struct {
+	// Path is the file the code was generated for.
+	Path string
+	// Source is the raw generated code, before formatting.
+	Source []byte
+	// Line is the line of `Source` the error is on.
+	Line int
+
+	// Template is the name of the template file that generated `Line` and
+	// TemplateLine the line within it, which is 0 if it isn't known.  Both are
+	// empty if the line wasn't generated by a template, e.g. it's an import.
+	Template     string
+	TemplateLine int
+	// Type is the fully qualified name of the type the template was invoked on
+	// and Args the arguments it was invoked with.
+	Type string
+	Args map[string]string
+
+	Err error
+}
+
+// generatedCodeContext is the number of lines shown on either side of the
+// line with the error.
+const generatedCodeContext = 2
+
+func (e *GeneratedCodeError) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "parsing generated code: %s", e.Err)
+	if e.Template != "" {
+		fmt.Fprintf(&msg, "\n  generated by %s", e.Template)
+		if e.TemplateLine > 0 {
+			fmt.Fprintf(&msg, ", line %d", e.TemplateLine)
+		}
+		fmt.Fprintf(&msg, ", invoked on %s", e.Type)
+		if len(e.Args) > 0 {
+			fmt.Fprintf(&msg, " with %s", formatArgs(e.Args))
+		}
+	}
+
+	lines := strings.Split(string(e.Source), "\n")
+	first, last := e.Line-generatedCodeContext, e.Line+generatedCodeContext
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(fmt.Sprint(last))
+	for i := first; i <= last; i++ {
+		marker := " "
+		if i == e.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(&msg, "\n  %s %*d | %s", marker, width, i, lines[i-1])
+	}
+	return msg.String()
+}
+
+func (e *GeneratedCodeError) Unwrap() error {
+	return e.Err
+}
+
+func newGeneratedCodeError(
+	ctx *GenContext,
+	filePath string,
+	source []byte,
+	startLines []int,
+	err error,
+) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		return errors.Wrap(err, "parsing generated code")
+	}
+
+	e := &GeneratedCodeError{
+		Path:   filePath,
+		Source: source,
+		Line:   list[0].Pos.Line,
+		Err:    err,
+	}
+	src := ctx.lineSource(startLines, e.Line)
+	e.Template, e.TemplateLine = src.Template, src.TemplateLine
+	e.Type, e.Args = src.Type, src.Args
+	return e
+}
+
+func formatArgs(args map[string]string) string {
+	pairs := make([]string, 0, len(args))
+	for k, v := range args {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -2,75 +2,623 @@ package codegen
 
 import (
 	"fmt"
+	"go/ast"
 	"go/token"
 	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"text/template"
 
 	"github.com/pkg/errors"
 	"golang.org/x/tools/go/packages"
 )
 
+// Generator runs code generation over files or packages.  The zero value
+// writes generated code to disk, which is what the package level `ProcessFile`
+// and `ProcessPackages` functions do.  `GenerateFiles` and `GeneratePackages`
+// instead return the generated code in memory.  They ignore `Check`, `Stdout`
+// and `SaveBroken`, which only control how `ProcessFile` and `ProcessPackages`
+// emit it, but respect the other options.
+type Generator struct {
+	// Check compares generated code with the files already on disk instead of
+	// writing it.  A unified diff is printed for every file that is out of date,
+	// including files generated from a source file that no longer contains any
+	// codegen tags, and an error listing them is returned.
+	Check bool
+
+	// Stdout writes generated code to standard output, with a header naming the
+	// file it would have been written to, instead of writing it to disk.
+	Stdout bool
+
+	// OutputName is a template for the name of the file generated for each
+	// source file, e.g. `{{.Base}}_gen.go` or `zz_generated_{{.Base}}.go`.  It
+	// is executed with an `OutputNameData` and the result is placed in the
+	// source file's directory.  Defaults to `DefaultOutputName`.
+	OutputName string
+
+	// Combined generates a single file per package, holding the code for every
+	// file in the package that contains codegen tags.  All of those files share
+	// one `GenContext`, so imports are merged and identical invocations are
+	// only run once.  The file is named by executing `OutputName` with `Base`
+	// set to `CombinedBase`, i.e. `codegen_generated.go` by default.  When
+	// given files, every file in their packages is scanned, not only those
+	// given.
+	Combined bool
+
+	// AddMissingImports imports the standard library packages the generated
+	// code uses without importing them, like goimports.  Unused imports are
+	// always removed.
+	AddMissingImports bool
+
+	// SaveBroken writes the raw output of templates that generate invalid go
+	// code next to the file it was generated for, with a `.broken` extension,
+	// so it can be inspected.
+	SaveBroken bool
+
+	// LineDirectives annotates generated code with `//line` directives so
+	// compiler errors and stack traces point at the template line that
+	// generated the code, and notes the type each template was invoked on.
+	LineDirectives bool
+
+	// TemplatePath lists directories to look for templates in when they aren't
+	// found next to their gen type, ahead of any listed in a
+	// `TemplatePathConfig` file.  A template for the gen type `Foo` in the
+	// package `example.com/gen` is looked for at `example.com/gen/Foo.tmpl`
+	// within each directory, then at `Foo.tmpl`.
+	TemplatePath []string
+}
+
+// DefaultOutputName is the `OutputName` used when none is set, which places
+// the code generated for `foo.go` in `foo_generated.go`.
+const DefaultOutputName = "{{.Base}}_generated.go"
+
+// CombinedBase is the `Base` the `OutputName` template is executed with to name
+// the single file generated per package when `Combined` is set.
+const CombinedBase = "codegen"
+
+// OutputNameData is available to the `OutputName` template.
+type OutputNameData struct {
+	// Base is the name of the source file without its directory or `.go`
+	// extension.
+	Base string
+	// Package is the name of the package the source file belongs to.
+	Package string
+}
+
 func ProcessFile(filePaths ...string) error {
+	return (&Generator{}).ProcessFile(filePaths...)
+}
+
+// ProcessPackages loads every package matching the given `go list` style
+// patterns (e.g. `./...`) in a single pass and generates code for each file
+// within them that contains codegen tags.  Unlike `ProcessFile`, files without
+// any codegen tags are silently skipped.  Like `ProcessFile`, it prints every
+// warning and error to stderr, except for the first error, which it returns.
+func ProcessPackages(patterns ...string) error {
+	return (&Generator{}).ProcessPackages(patterns...)
+}
+
+func (g *Generator) ProcessFile(filePaths ...string) error {
+	result, err := g.GenerateFiles(filePaths...)
+	if err != nil {
+		return err
+	}
+	printDiagnostics(result)
+	if err := result.Err(); err != nil {
+		if g.SaveBroken {
+			g.saveBroken(result)
+		}
+		return err
+	}
+	return g.emit(result)
+}
+
+// ProcessPackages is the `Generator` equivalent of the package level
+// `ProcessPackages`.
+func (g *Generator) ProcessPackages(patterns ...string) error {
+	result, err := g.GeneratePackages(patterns...)
+	if err != nil {
+		return err
+	}
+	printDiagnostics(result)
+	if err := result.Err(); err != nil {
+		if g.SaveBroken {
+			g.saveBroken(result)
+		}
+		return err
+	}
+	return g.emit(result)
+}
+
+// GenerateFiles generates code for the given go files like `ProcessFile`, but
+// returns the generated code in memory instead of writing it.  The returned
+// error is only non-nil if the files could not be loaded; problems generating
+// individual files are reported as diagnostics in the result.
+func (g *Generator) GenerateFiles(filePaths ...string) (*Result, error) {
 	patterns := make([]string, len(filePaths), len(filePaths))
 	for i, filePath := range filePaths {
 		if !strings.HasSuffix(filePath, ".go") {
-			return errors.New(filePath + " does not reference a go file")
+			return nil, errors.New(filePath + " does not reference a go file")
 		}
 		patterns[i] = fmt.Sprint("file=", filePath)
 	}
 
+	outputName, err := g.outputNameTemplate()
+	if err != nil {
+		return nil, err
+	}
+
 	fset := token.NewFileSet()
+	pkgs, err := loadPackages(fset, patterns...)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing file")
+	}
+
+	filePathToPkg, err := generatePathToPackageMap(filePaths, pkgs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map file paths to packages")
+	}
+
+	// Group the files by package, keeping them in the order they were given.
+	var pkgOrder []*packages.Package
+	pkgFiles := make(map[*packages.Package][]string)
+	for _, filePath := range filePaths {
+		pkg := filePathToPkg[filePath]
+		if _, ok := pkgFiles[pkg]; !ok {
+			pkgOrder = append(pkgOrder, pkg)
+		}
+		pkgFiles[pkg] = append(pkgFiles[pkg], filePath)
+	}
+
+	result := &Result{Files: make(map[string][]byte)}
+	for _, pkg := range pkgOrder {
+		given := pkgFiles[pkg]
+		if !g.Combined {
+			genPaths, err := g.outputPaths(outputName, pkg, given)
+			if err != nil {
+				return nil, err
+			}
+			untagged := g.generatePackage(result, pkg, given, genPaths, fset)
+			reportUntagged(result, untagged)
+			continue
+		}
+
+		// The combined file holds the code for the whole package, so every file
+		// in it is scanned, not only those given, or generating for one file
+		// would drop the code generated for the others.
+		genPaths, err := g.outputPaths(outputName, pkg, pkg.GoFiles)
+		if err != nil {
+			return nil, err
+		}
+		givenFiles := make(map[string]bool, len(given))
+		for _, filePath := range given {
+			givenFiles[filePath] = true
+		}
+		var untagged []string
+		for _, filePath := range g.generatePackage(
+			result, pkg, sourceFiles(pkg, genPaths), genPaths, fset,
+		) {
+			if givenFiles[filePath] {
+				untagged = append(untagged, filePath)
+			}
+		}
+		reportUntagged(result, untagged)
+	}
+
+	return result, nil
+}
+
+// reportUntagged adds an error to `result` for each of `filePaths`, which were
+// explicitly given but contain no codegen tags.
+func reportUntagged(result *Result, filePaths []string) {
+	for _, filePath := range filePaths {
+		result.addDiagnostic(
+			SeverityError,
+			filePath,
+			errors.New("No codegen tags detected in file "+filePath),
+		)
+	}
+}
+
+// GeneratePackages generates code for the packages matching the given patterns
+// like `ProcessPackages`, but returns the generated code in memory instead of
+// writing it.  The returned error is only non-nil if the packages could not be
+// loaded; problems generating individual files are reported as diagnostics in
+// the result.
+func (g *Generator) GeneratePackages(patterns ...string) (*Result, error) {
+	outputName, err := g.outputNameTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := loadPackages(fset, patterns...)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading packages")
+	}
+
+	result := &Result{Files: make(map[string][]byte)}
+	for _, pkg := range pkgs {
+		// Type errors are tolerated as they are often caused by code that has
+		// not been generated yet, but a pattern that can't be resolved to a
+		// package is almost certainly a mistake.
+		for _, pkgErr := range pkg.Errors {
+			if pkgErr.Kind == packages.ListError {
+				return nil, errors.Errorf("loading package %s: %s", pkg.PkgPath, pkgErr.Msg)
+			}
+			result.addDiagnostic(SeverityWarning, pkgErr.Pos, errors.New(pkgErr.Msg))
+		}
+		if len(pkg.GoFiles) == 0 {
+			continue
+		}
+
+		genPaths, err := g.outputPaths(outputName, pkg, pkg.GoFiles)
+		if err != nil {
+			return nil, err
+		}
+		g.generatePackage(result, pkg, sourceFiles(pkg, genPaths), genPaths, fset)
+	}
+
+	return result, nil
+}
+
+// sourceFiles returns the files of `pkg` that aren't among the files code is
+// generated into, according to `genPaths`.  Files previously written by
+// go-codegen are never themselves scanned for codegen tags.
+func sourceFiles(pkg *packages.Package, genPaths map[string]string) []string {
+	generated := make(map[string]struct{}, len(genPaths))
+	for _, genPath := range genPaths {
+		generated[genPath] = struct{}{}
+	}
+	var filePaths []string
+	for _, filePath := range pkg.GoFiles {
+		if _, ok := generated[filePath]; !ok {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	return filePaths
+}
+
+func loadPackages(fset *token.FileSet, patterns ...string) ([]*packages.Package, error) {
 	cfg := &packages.Config{
 		Fset: fset,
 		Mode: packages.NeedName |
 			packages.NeedTypes |
 			packages.NeedDeps |
-			packages.NeedFiles,
+			packages.NeedFiles |
+			packages.NeedSyntax,
 	}
+	return packages.Load(cfg, patterns...)
+}
 
-	pkgs, err := packages.Load(cfg, patterns...)
-	if err != nil {
-		return errors.Wrap(err, "parsing file")
+// generatePackage generates code for `filePaths`, which all belong to `pkg`,
+// into the files given by `genPaths` and adds it to `result`.  Errors are
+// reported as diagnostics, and the files that contain no codegen tags are
+// returned.
+func (g *Generator) generatePackage(
+	result *Result,
+	pkg *packages.Package,
+	filePaths []string,
+	genPaths map[string]string,
+	fset *token.FileSet,
+) (untagged []string) {
+	fail := func(filePath string, err error) {
+		result.addDiagnostic(SeverityError, filePath, errors.Wrapf(err, "package %s", pkg.PkgPath))
 	}
 
-	filePathToPkg, err := generatePathToPackageMap(filePaths, pkgs)
+	templatePath, err := g.templatePath(pkg)
 	if err != nil {
-		return errors.Wrapf(err, "failed to map file paths to packages")
+		fail(pkg.PkgPath, err)
+		return nil
 	}
 
-	for filePath, pkg := range filePathToPkg {
-		structs := findStructsInFile(filePath, pkg, fset)
+	if !g.Combined {
+		for _, filePath := range filePaths {
+			ctx := g.newPackageContext(fset, pkg, templatePath, genPaths)
+			if err := generateFile(ctx, filePath, pkg, fset); err != nil {
+				fail(filePath, err)
+				continue
+			}
+			if len(ctx.Generated()) == 0 {
+				untagged = append(untagged, filePath)
+				result.addUntagged(genPaths[filePath])
+				continue
+			}
 
-		ctx := NewGenContext(fset, pkg.Types)
-		for _, s := range structs {
-			if err := processStruct(s, ctx); err != nil {
-				return errors.Wrapf(err, "processing struct %s", s.Obj().Name())
+			genPath := genPaths[filePath]
+			content, err := Render(ctx, genPath)
+			if err != nil {
+				fail(filePath, errors.Wrap(err, "generating code for "+genPath))
+				continue
 			}
+			result.addFile(filePath, genPath, content)
 		}
+		return untagged
+	}
 
-		if len(ctx.Generated()) == 0 {
-			return errors.New("No codegen tags detected in file " + filePath)
+	ctx := g.newPackageContext(fset, pkg, templatePath, genPaths)
+	failed := false
+	for _, filePath := range filePaths {
+		before := len(ctx.Generated())
+		if err := generateFile(ctx, filePath, pkg, fset); err != nil {
+			fail(filePath, err)
+			failed = true
+			continue
+		}
+		if len(ctx.Generated()) == before {
+			untagged = append(untagged, filePath)
 		}
+	}
+	// Don't return a partial file if any part of the package failed.
+	if failed || len(filePaths) == 0 {
+		return untagged
+	}
+	genPath := genPaths[filePaths[0]]
+	if len(ctx.Generated()) == 0 {
+		result.addUntagged(genPath)
+		return untagged
+	}
+
+	content, err := Render(ctx, genPath)
+	if err != nil {
+		fail(pkg.PkgPath, errors.Wrap(err, "generating code for "+genPath))
+		return untagged
+	}
+	result.addFile(pkg.PkgPath, genPath, content)
+	return untagged
+}
 
-		base := filePath[:len(filePath)-len(".go")]
-		genPath := base + "_generated.go"
-		if err := Output(ctx, genPath); err != nil {
-			return errors.Wrap(err, "writing generated code to "+genPath)
+// newPackageContext returns a `GenContext` for generating code into `pkg`,
+// with access to its syntax, that looks for templates along `templatePath`.
+// The declarations in the files of `genPaths` are ignored.
+func (g *Generator) newPackageContext(
+	fset *token.FileSet,
+	pkg *packages.Package,
+	templatePath []string,
+	genPaths map[string]string,
+) *GenContext {
+	ctx := NewGenContext(fset, pkg.Types)
+	ctx.AddMissingImports = g.AddMissingImports
+	ctx.LineDirectives = g.LineDirectives
+	ctx.TemplatePath = templatePath
+	ctx.syntax = pkg.Syntax
+	ctx.outputFiles = make(map[string]bool, len(genPaths))
+	for _, genPath := range genPaths {
+		ctx.outputFiles[genPath] = true
+	}
+	if len(pkg.GoFiles) > 0 {
+		ctx.dir = filepath.Dir(pkg.GoFiles[0])
+	}
+	return ctx
+}
+
+// templatePath returns the template search path for `pkg`, which is the
+// generator's `TemplatePath` followed by the directories listed in the nearest
+// `TemplatePathConfig` file.
+func (g *Generator) templatePath(pkg *packages.Package) ([]string, error) {
+	templatePath := append([]string(nil), g.TemplatePath...)
+	if len(pkg.GoFiles) == 0 {
+		return templatePath, nil
+	}
+	configured, err := readTemplatePathConfig(filepath.Dir(pkg.GoFiles[0]))
+	if err != nil {
+		return nil, err
+	}
+	return append(templatePath, configured...), nil
+}
+
+// generateFile runs every template invoked by the types defined in `filePath`,
+// adding the generated code to `ctx`.
+func generateFile(
+	ctx *GenContext,
+	filePath string,
+	pkg *packages.Package,
+	fset *token.FileSet,
+) error {
+	file := syntaxForFile(filePath, pkg, fset)
+	if file == nil {
+		return errors.New("no syntax loaded for " + filePath)
+	}
+	docs := typeDocs(file)
+
+	for _, t := range findTypesInFile(filePath, pkg, fset) {
+		directives, err := InvocationsForDirectives(docs[t.Obj().Name()], file, pkg.Types)
+		if err != nil {
+			return errors.Wrapf(err, "processing type %s", t.Obj().Name())
+		}
+		if err := processType(t, directives, ctx); err != nil {
+			return errors.Wrapf(err, "processing type %s", t.Obj().Name())
 		}
-		fmt.Printf("Wrote %s.\n", genPath)
 	}
+	return nil
+}
 
+// syntaxForFile returns the parsed syntax of `filePath` from `pkg`.
+func syntaxForFile(filePath string, pkg *packages.Package, fset *token.FileSet) *ast.File {
+	for _, file := range pkg.Syntax {
+		if fset.Position(file.Pos()).Filename == filePath {
+			return file
+		}
+	}
 	return nil
 }
 
-func processStruct(aStruct *types.Named, ctx *GenContext) error {
-	invocations, err := InvocationsForStruct(aStruct.Underlying().(*types.Struct))
+func (g *Generator) outputNameTemplate() (*template.Template, error) {
+	text := g.OutputName
+	if text == "" {
+		text = DefaultOutputName
+	}
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing output name template")
+	}
+	return tmpl, nil
+}
+
+// outputPaths maps each of `filePaths`, which all belong to `pkg`, onto the
+// path of the file the code generated for it is written to.  When `Combined` is
+// set, they all map onto the same file.
+func (g *Generator) outputPaths(
+	outputName *template.Template,
+	pkg *packages.Package,
+	filePaths []string,
+) (map[string]string, error) {
+	genPaths := make(map[string]string, len(filePaths))
+	if g.Combined {
+		dir := filepath.Dir(filePaths[0])
+		genPath, err := outputPath(outputName, filepath.Join(dir, CombinedBase+".go"), pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, filePath := range filePaths {
+			genPaths[filePath] = genPath
+		}
+		return genPaths, nil
+	}
+
+	for _, filePath := range filePaths {
+		genPath, err := outputPath(outputName, filePath, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		genPaths[filePath] = genPath
+	}
+	return genPaths, nil
+}
+
+// outputPath returns the path of the file that code generated for `filePath`
+// is written to, according to the `OutputName` template.
+func outputPath(outputName *template.Template, filePath, pkgName string) (string, error) {
+	var name strings.Builder
+	err := outputName.Execute(&name, OutputNameData{
+		Base:    strings.TrimSuffix(filepath.Base(filePath), ".go"),
+		Package: pkgName,
+	})
 	if err != nil {
-		return errors.Wrap(err, "extracting template invocations")
+		return "", errors.Wrap(err, "executing output name template")
+	}
+	if !strings.HasSuffix(name.String(), ".go") ||
+		strings.ContainsRune(name.String(), filepath.Separator) {
+		return "", errors.Errorf(
+			"output name %q must be a go file name without a directory", name.String(),
+		)
+	}
+	genPath := filepath.Join(filepath.Dir(filePath), name.String())
+	if genPath == filePath {
+		return "", errors.Errorf("output name %q would overwrite its source file", name.String())
+	}
+	return genPath, nil
+}
+
+// printDiagnostics prints every diagnostic in `result` to stderr, except for
+// the error returned by `result.Err`, which is left to the caller to report.
+func printDiagnostics(result *Result) {
+	returned := -1
+	for i, d := range result.Diagnostics {
+		if d.Severity == SeverityError {
+			returned = i
+			break
+		}
+	}
+	for i, d := range result.Diagnostics {
+		if i != returned {
+			fmt.Fprintln(os.Stderr, d)
+		}
+	}
+}
+
+// saveBroken writes the raw generated code of every invalid file in `result`
+// to a `.broken` file.  Failures are only logged, so they don't hide the error
+// that made the code invalid.
+func (g *Generator) saveBroken(result *Result) {
+	for _, d := range result.Diagnostics {
+		e, ok := errors.Cause(d.Err).(*GeneratedCodeError)
+		if !ok {
+			continue
+		}
+		brokenPath := e.Path + ".broken"
+		if err := ioutil.WriteFile(brokenPath, e.Source, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %s\n", brokenPath, err)
+			continue
+		}
+		fmt.Printf("Wrote %s.\n", brokenPath)
+	}
+}
+
+// emit writes the generated files in `result` to disk, or compares them with
+// the files already there when checking, or prints them when writing to stdout.
+func (g *Generator) emit(result *Result) error {
+	var stale []string
+	for _, genPath := range result.Paths() {
+		content := result.Files[genPath]
+		switch {
+		case g.Check:
+			diff, err := checkGenerated(genPath, content)
+			if err != nil {
+				return errors.Wrap(err, "checking generated code in "+genPath)
+			}
+			fmt.Print(diff)
+			if diff != "" {
+				stale = append(stale, genPath)
+			}
+		case g.Stdout:
+			if err := printGenerated(os.Stdout, genPath, content); err != nil {
+				return errors.Wrap(err, "printing generated code for "+genPath)
+			}
+		default:
+			if err := ioutil.WriteFile(genPath, content, 0644); err != nil {
+				return errors.Wrap(err, "writing generated code to "+genPath)
+			}
+			fmt.Printf("Wrote %s.\n", genPath)
+		}
+	}
+
+	// A generated file left behind by a source file that no longer contains
+	// any codegen tags is stale too, and should be deleted.
+	if g.Check {
+		for _, genPath := range result.untagged {
+			diff, err := checkGenerated(genPath, nil)
+			if err != nil {
+				return errors.Wrap(err, "checking generated code in "+genPath)
+			}
+			fmt.Print(diff)
+			if diff != "" {
+				stale = append(stale, genPath)
+			}
+		}
+	}
+	return staleError(stale)
+}
+
+// staleError returns an error listing the generated files found to be out of
+// date, or nil if there are none.
+func staleError(stale []string) error {
+	if len(stale) == 0 {
+		return nil
+	}
+	return errors.Errorf(
+		"%d generated file(s) out of date:\n  %s",
+		len(stale), strings.Join(stale, "\n  "),
+	)
+}
+
+// processType runs the templates invoked on `named`, first those invoked by the
+// `directives` on its declaration and then, for a struct, those invoked by its
+// fields.
+func processType(named *types.Named, directives []Invocation, ctx *GenContext) error {
+	invocations := directives
+	if aStruct, ok := named.Underlying().(*types.Struct); ok {
+		fromFields, err := InvocationsForStruct(aStruct)
+		if err != nil {
+			return errors.Wrap(err, "extracting template invocations")
+		}
+		invocations = append(invocations, fromFields...)
 	}
 
 	for _, invocation := range invocations {
-		if err := ctx.RunTemplate(invocation, aStruct); err != nil {
+		if err := ctx.RunTemplate(invocation, named); err != nil {
 			return errors.Wrap(err, "running template")
 		}
 	}
@@ -78,28 +626,30 @@ func processStruct(aStruct *types.Named, ctx *GenContext) error {
 	return nil
 }
 
-func findStructsInFile(
+// findTypesInFile returns the named types declared in `filePath`, excluding
+// aliases.
+func findTypesInFile(
 	filePath string,
 	pkg *packages.Package,
 	fset *token.FileSet,
 ) []*types.Named {
-	var structs []*types.Named
+	var named []*types.Named
 	scope := pkg.Types.Scope()
 	for _, name := range scope.Names() {
-		object := scope.Lookup(name)
-		namedType, ok := object.Type().(*types.Named)
-		if !ok {
+		typeName, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || typeName.IsAlias() {
 			continue
 		}
-		if _, ok = namedType.Underlying().(*types.Struct); !ok {
+		namedType, ok := typeName.Type().(*types.Named)
+		if !ok {
 			continue
 		}
-		fpath := fset.Position(object.Pos()).Filename
+		fpath := fset.Position(typeName.Pos()).Filename
 		if fpath == filePath {
-			structs = append(structs, namedType)
+			named = append(named, namedType)
 		}
 	}
-	return structs
+	return named
 }
 
 func generatePathToPackageMap(filePaths []string, pkgs []*packages.Package) (map[string]*packages.Package, error) {
@@ -0,0 +1,119 @@
+package codegen
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Result holds generated code in memory, as returned by `GenerateFiles` and
+// `GeneratePackages`, leaving it up to the caller whether and where to write it.
+type Result struct {
+	// Files maps the path each generated file would be written to onto its
+	// formatted contents.
+	Files map[string][]byte
+
+	// Diagnostics lists the problems encountered while generating, in the order
+	// they were found.  Files that failed to generate are absent from `Files`.
+	Diagnostics []Diagnostic
+
+	// sources maps each generated file onto the source file it was generated
+	// from.
+	sources map[string]string
+
+	// untagged lists the files code would have been generated into for source
+	// files without codegen tags, which are stale if they exist.
+	untagged []string
+}
+
+// Paths returns the paths of the generated files in sorted order.
+func (r *Result) Paths() []string {
+	paths := make([]string, 0, len(r.Files))
+	for path := range r.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Err returns the error of the first diagnostic with `SeverityError`, or nil if
+// generation succeeded.
+func (r *Result) Err() error {
+	for _, d := range r.Diagnostics {
+		if d.Severity == SeverityError {
+			return d.Err
+		}
+	}
+	return nil
+}
+
+// addFile records the code generated from `filePath`, reporting an error if
+// another source file already generated code into the same `genPath`.
+func (r *Result) addFile(filePath, genPath string, content []byte) {
+	if other, ok := r.sources[genPath]; ok {
+		r.addDiagnostic(SeverityError, filePath, errors.Errorf(
+			"both %s and %s generate code into %s", other, filePath, genPath,
+		))
+		return
+	}
+	if r.sources == nil {
+		r.sources = make(map[string]string)
+	}
+	r.sources[genPath] = filePath
+	r.Files[genPath] = content
+}
+
+// addUntagged records that no code was generated into `genPath` because its
+// source files contain no codegen tags.
+func (r *Result) addUntagged(genPath string) {
+	r.untagged = append(r.untagged, genPath)
+}
+
+func (r *Result) addDiagnostic(severity Severity, pos string, err error) {
+	r.Diagnostics = append(r.Diagnostics, Diagnostic{
+		Severity: severity,
+		Pos:      pos,
+		Err:      err,
+	})
+}
+
+// Severity classifies a `Diagnostic`.
+type Severity int
+
+const (
+	// SeverityError means code could not be generated for the file the
+	// diagnostic refers to.
+	SeverityError Severity = iota
+	// SeverityWarning means generation continued, but the output may be
+	// affected.  Type errors in the loaded packages are reported as warnings, as
+	// they are often caused by code that has not been generated yet.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic describes a single problem encountered while generating code.
+type Diagnostic struct {
+	Severity Severity
+	// Pos is the source position (`file:line:col`) or file the diagnostic refers
+	// to, if known.
+	Pos string
+	Err error
+}
+
+func (d Diagnostic) String() string {
+	if d.Pos == "" {
+		return fmt.Sprintf("%s: %v", d.Severity, d.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", d.Pos, d.Severity, d.Err)
+}
@@ -0,0 +1,322 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// lineMarkerDelim surrounds the markers inserted into templates to track which
+// template line produced each line of output.  NUL bytes can't appear in go
+// source, so they can't be confused with anything a template generates.
+const lineMarkerDelim = "\x00"
+
+// templatePos is a position within a template file.
+type templatePos struct {
+	Template string
+	Line     int
+	// Path is the path of the template file, or its name if it wasn't parsed
+	// from disk.
+	Path string
+}
+
+func (p templatePos) String() string {
+	return fmt.Sprintf("%s:%d", p.Template, p.Line)
+}
+
+// instrumentTemplate inserts markers into the text of every template in the set
+// `t` belongs to, recording the template file and line each line of text comes
+// from.  `dir` is the directory the set was parsed from, or empty if it wasn't
+// parsed from disk.  The markers must be removed from the output with
+// `stripLineMarkers`.
+func instrumentTemplate(t *template.Template, dir string) {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil && tmpl.Tree.Root != nil {
+			path := tmpl.Tree.ParseName
+			if dir != "" {
+				path = filepath.Join(dir, path)
+			}
+			instrumentNode(tmpl.Tree, path, tmpl.Tree.Root)
+		}
+	}
+}
+
+func instrumentNode(tree *parse.Tree, path string, node parse.Node) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			instrumentNode(tree, path, n)
+		}
+	case *parse.IfNode:
+		instrumentNode(tree, path, node.List)
+		instrumentNode(tree, path, node.ElseList)
+	case *parse.RangeNode:
+		instrumentNode(tree, path, node.List)
+		instrumentNode(tree, path, node.ElseList)
+	case *parse.WithNode:
+		instrumentNode(tree, path, node.List)
+		instrumentNode(tree, path, node.ElseList)
+	case *parse.TextNode:
+		// `ErrorContext` gives the location as `name:line:col`.
+		location, _ := tree.ErrorContext(node)
+		parts := strings.Split(location, ":")
+		if len(parts) < 3 {
+			return
+		}
+		line, err := strconv.Atoi(parts[len(parts)-2])
+		if err != nil {
+			return
+		}
+
+		var text bytes.Buffer
+		text.WriteString(lineMarker(path, line))
+		for _, c := range node.Text {
+			text.WriteByte(c)
+			if c == '\n' {
+				line++
+				text.WriteString(lineMarker(path, line))
+			}
+		}
+		node.Text = text.Bytes()
+	}
+}
+
+func lineMarker(path string, line int) string {
+	return lineMarkerDelim + path + ":" + strconv.Itoa(line) + lineMarkerDelim
+}
+
+// stripLineMarkers removes the markers inserted by `instrumentTemplate` from
+// the output of a template.  It returns the output along with the template
+// position each of its lines came from.
+func stripLineMarkers(output string) (string, []templatePos) {
+	var stripped strings.Builder
+	var current templatePos
+	lines := []templatePos{current}
+	lineStart := true
+
+	for {
+		start := strings.Index(output, lineMarkerDelim)
+		if start == -1 {
+			break
+		}
+		end := strings.Index(output[start+1:], lineMarkerDelim)
+		if end == -1 {
+			break
+		}
+		end += start + 1
+
+		text := output[:start]
+		for _, c := range text {
+			stripped.WriteRune(c)
+			if c == '\n' {
+				lines = append(lines, current)
+				lineStart = true
+			} else if c != ' ' && c != '\t' {
+				lineStart = false
+			}
+		}
+
+		marker := output[start+1 : end]
+		if colon := strings.LastIndex(marker, ":"); colon != -1 {
+			if line, err := strconv.Atoi(marker[colon+1:]); err == nil {
+				path := marker[:colon]
+				current = templatePos{Template: filepath.Base(path), Line: line, Path: path}
+				// A line is attributed to the template line its first character,
+				// ignoring indentation, came from.
+				if lineStart {
+					lines[len(lines)-1] = current
+				}
+			}
+		}
+		output = output[end+1:]
+	}
+
+	for _, c := range output {
+		stripped.WriteRune(c)
+		if c == '\n' {
+			lines = append(lines, current)
+		}
+	}
+	return stripped.String(), lines
+}
+
+// addLineDirectives annotates the raw generated code for `filePath` with a
+// comment before each chunk naming the template and the type it was invoked on,
+// and a `/*line*/` directive before the first token of each line a template
+// generated, pointing at the template line it came from.  `startLines` holds the
+// line each chunk of `ctx.generated` starts on in `source`.
+//
+// The `/*line*/` form is used because it stays attached to its token when the
+// code is formatted, which may indent it or move it onto a line of its own.
+// Placing it before a token or comment ensures it never ends up within a
+// multi-line string or comment.  Once formatted, `compactLineDirectives` turns
+// them into `//line` directives.
+func addLineDirectives(ctx *GenContext, filePath string, source []byte, startLines []int) []byte {
+	type insertion struct {
+		offset int
+		text   string
+	}
+	var insertions []insertion
+
+	fset := token.NewFileSet()
+	file := fset.AddFile(filePath, -1, len(source))
+	var s scanner.Scanner
+	s.Init(file, source, nil, scanner.ScanComments)
+	lineOffsets := []int{0}
+	for i, c := range source {
+		if c == '\n' {
+			lineOffsets = append(lineOffsets, i+1)
+		}
+	}
+	for i, start := range startLines {
+		// Don't name invocations that generated nothing.
+		if strings.TrimSpace(ctx.generated[i]) == "" {
+			continue
+		}
+		src := ctx.sources[i]
+		typeName := src.invocation.StructName
+		typeName = typeName[strings.LastIndex(typeName, ".")+1:]
+		insertions = append(insertions, insertion{
+			offset: lineOffsets[start-1],
+			text: fmt.Sprintf(
+				"// Generated by %s for %s (%s:%d).\n\n",
+				src.template,
+				typeName,
+				relativePath(filePath, src.site.Filename),
+				src.site.Line,
+			),
+		})
+	}
+
+	chunk, prevLine := -1, 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		line := file.Line(pos)
+		// Skip semicolons inserted automatically at the end of each line.
+		if line <= prevLine || (tok == token.SEMICOLON && lit == "\n") {
+			continue
+		}
+		prevLine = line
+
+		for chunk+1 < len(startLines) && startLines[chunk+1] <= line {
+			chunk++
+		}
+		// Lines before the first chunk are the package clause and imports.
+		if chunk == -1 {
+			continue
+		}
+		lines := ctx.sources[chunk].lines
+		offset := line - startLines[chunk]
+		if offset >= len(lines) || lines[offset].Line == 0 {
+			continue
+		}
+		insertions = append(insertions, insertion{
+			offset: file.Offset(pos),
+			text: fmt.Sprintf(
+				"/*line %s:%d*/",
+				relativePath(filePath, lines[offset].Path),
+				lines[offset].Line,
+			),
+		})
+	}
+
+	sort.SliceStable(insertions, func(i, j int) bool {
+		return insertions[i].offset < insertions[j].offset
+	})
+	var annotated bytes.Buffer
+	copied := 0
+	for _, i := range insertions {
+		annotated.Write(source[copied:i.offset])
+		annotated.WriteString(i.text)
+		copied = i.offset
+	}
+	annotated.Write(source[copied:])
+	return annotated.Bytes()
+}
+
+// relativePath returns `path` relative to the directory of `filePath`, using
+// forward slashes so generated code doesn't depend on the platform or the
+// location of the checkout.
+func relativePath(filePath, path string) string {
+	rel, err := filepath.Rel(filepath.Dir(filePath), path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+var blockLineDirective = regexp.MustCompile(`^(\s*)/\*line (.*):(\d+)\*/ ?`)
+
+// compactLineDirectives replaces the `/*line*/` directives added by
+// `addLineDirectives` to formatted code with `//line` directives on lines of
+// their own, which are only needed where a line doesn't follow on from the
+// template line before it.  The result is formatted again, as removing the
+// comments can change the alignment of the code around them.
+func compactLineDirectives(formatted []byte) ([]byte, error) {
+	lines := strings.SplitAfter(string(formatted), "\n")
+	positions := make([]templatePos, len(lines))
+	for i, line := range lines {
+		m := blockLineDirective.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		rest := line[len(m[0]):]
+		lineNum, _ := strconv.Atoi(m[3])
+		pos := templatePos{Template: m[2], Line: lineNum}
+		if strings.TrimSpace(rest) == "" {
+			// Formatting moved the directive onto a line of its own, before the
+			// line holding the token it was attached to.
+			lines[i] = ""
+			if i+1 < len(lines) {
+				positions[i+1] = pos
+			}
+			continue
+		}
+		lines[i] = m[1] + rest
+		if positions[i].Line == 0 {
+			positions[i] = pos
+		}
+	}
+
+	var compacted strings.Builder
+	// current is the position the compiler will assign to the next line.
+	var current templatePos
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		pos := positions[i]
+		if pos.Line > 0 && strings.HasPrefix(strings.TrimSpace(line), "//") {
+			// A directive before a doc comment would be moved below it by gofmt,
+			// so it's placed on the line after the comment instead.
+			if i+1 < len(lines) && positions[i+1].Line == 0 {
+				positions[i+1] = templatePos{Template: pos.Template, Line: pos.Line + 1}
+			}
+			pos = templatePos{}
+		}
+		if pos.Line > 0 && pos != current {
+			fmt.Fprintf(&compacted, "//line %s\n", pos)
+			current = pos
+		}
+		compacted.WriteString(line)
+		if current.Line > 0 {
+			current.Line++
+		}
+	}
+	return format.Source([]byte(compacted.String()))
+}
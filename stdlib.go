@@ -0,0 +1,207 @@
+package codegen
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// addMissingImports imports the standard library packages that `file` selects
+// from without importing them, the way goimports does, but without looking
+// beyond the standard library so it works offline.  Names declared in `scope`,
+// the scope of the package `file` belongs to, are not treated as packages.
+// `names` maps the path of each existing import onto the name it is referred
+// to by.
+func addMissingImports(
+	fset *token.FileSet,
+	file *ast.File,
+	scope *types.Scope,
+	names map[string]string,
+) error {
+	imported := make(map[string]bool)
+	for _, spec := range file.Imports {
+		imported[importSpecName(spec, names)] = true
+	}
+
+	// Collect the names selected from each missing package, which are used to
+	// tell apart packages with the same name, like `math/rand` and
+	// `crypto/rand`.
+	missing := make(map[string]map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Obj != nil || imported[x.Name] ||
+			types.Universe.Lookup(x.Name) != nil ||
+			(scope != nil && scope.Lookup(x.Name) != nil) {
+			return true
+		}
+		if missing[x.Name] == nil {
+			missing[x.Name] = make(map[string]bool)
+		}
+		missing[x.Name][sel.Sel.Name] = true
+		return true
+	})
+	if len(missing) == 0 {
+		return nil
+	}
+
+	packages, err := stdlibPackages()
+	if err != nil {
+		return err
+	}
+	for name, selected := range missing {
+		if pkg := chooseStdlibPackage(packages[name], selected); pkg != "" {
+			astutil.AddImport(fset, file, pkg)
+		}
+	}
+	return nil
+}
+
+// chooseStdlibPackage returns the candidate package that exports every one of
+// the `selected` names, preferring the shortest path, or an empty string if
+// there is none.
+func chooseStdlibPackage(candidates []string, selected map[string]bool) string {
+	var matches []string
+CandidateLoop:
+	for _, pkg := range candidates {
+		exports := stdlibExports(pkg)
+		for name := range selected {
+			if !exports[name] {
+				continue CandidateLoop
+			}
+		}
+		matches = append(matches, pkg)
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if len(matches[i]) != len(matches[j]) {
+			return len(matches[i]) < len(matches[j])
+		}
+		return matches[i] < matches[j]
+	})
+	return matches[0]
+}
+
+var stdlib struct {
+	once     sync.Once
+	packages map[string][]string
+	err      error
+
+	exportsMu sync.Mutex
+	exports   map[string]map[string]bool
+}
+
+func stdlibSrc() string {
+	return filepath.Join(build.Default.GOROOT, "src")
+}
+
+// stdlibPackages maps the name of each importable standard library package
+// onto the paths of the packages with that name.
+func stdlibPackages() (map[string][]string, error) {
+	stdlib.once.Do(func() {
+		src := stdlibSrc()
+		stdlib.packages = make(map[string][]string)
+		stdlib.err = filepath.Walk(src, func(dir string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(src, dir)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			base := info.Name()
+			if rel == "cmd" || base == "internal" || base == "vendor" || base == "testdata" ||
+				strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") {
+				return filepath.SkipDir
+			}
+			if len(goFiles(dir)) > 0 {
+				name := assumedPackageName(rel)
+				stdlib.packages[name] = append(stdlib.packages[name], rel)
+			}
+			return nil
+		})
+	})
+	return stdlib.packages, stdlib.err
+}
+
+// stdlibExports returns the names exported by the standard library package
+// `pkg`, ignoring build constraints.
+func stdlibExports(pkg string) map[string]bool {
+	stdlib.exportsMu.Lock()
+	defer stdlib.exportsMu.Unlock()
+	if exports, ok := stdlib.exports[pkg]; ok {
+		return exports
+	}
+
+	exports := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, path := range goFiles(filepath.Join(stdlibSrc(), filepath.FromSlash(pkg))) {
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					exports[decl.Name.Name] = decl.Name.IsExported()
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						exports[spec.Name.Name] = spec.Name.IsExported()
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							exports[name.Name] = name.IsExported()
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if stdlib.exports == nil {
+		stdlib.exports = make(map[string]map[string]bool)
+	}
+	stdlib.exports[pkg] = exports
+	return exports
+}
+
+// goFiles returns the non-test go files in `dir`.
+func goFiles(dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files
+}
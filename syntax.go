@@ -0,0 +1,186 @@
+package codegen
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// docInfo is implemented by `TypeInfo`s with access to the syntax of the
+// package being generated, such as `GenContext`.
+type docInfo interface {
+	DocComment(obj types.Object) string
+	FieldDocComment(field *types.Var) string
+	FieldLineComment(field *types.Var) string
+}
+
+// DocComment returns the text of the doc comment on the declaration of `obj`,
+// without the comment markers or any `//codegen:` directives.  It returns an
+// empty string if there is no doc comment, or the syntax of the package
+// declaring `obj` isn't available.
+func (ctx *GenContext) DocComment(obj types.Object) string {
+	if ctx.docs == nil {
+		ctx.docs = indexDocs(ctx.syntax)
+	}
+	return commentText(ctx.docs[obj.Pos()])
+}
+
+// commentText returns the text of `doc` like `ast.CommentGroup.Text`, but
+// without any `//codegen:` directives.  `Text` only removes those naming a gen
+// type that starts with a lowercase letter or digit, like `//codegen:stackGen`.
+func commentText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	var list []*ast.Comment
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, directivePrefix) {
+			list = append(list, c)
+		}
+	}
+	return (&ast.CommentGroup{List: list}).Text()
+}
+
+// FieldDocComment returns the text of the doc comment above the declaration of
+// the struct field `field`, without the comment markers.  It returns an empty
+// string if there is no doc comment, or the syntax of the package declaring
+// `field` isn't available.
+func (ctx *GenContext) FieldDocComment(field *types.Var) string {
+	if f := ctx.fieldSyntax(field); f != nil {
+		return commentText(f.Doc)
+	}
+	return ""
+}
+
+// FieldLineComment returns the text of the comment following the declaration
+// of the struct field `field` on the same line, like `FieldDocComment`.
+func (ctx *GenContext) FieldLineComment(field *types.Var) string {
+	if f := ctx.fieldSyntax(field); f != nil {
+		return commentText(f.Comment)
+	}
+	return ""
+}
+
+func (ctx *GenContext) fieldSyntax(field *types.Var) *ast.Field {
+	if ctx.fields == nil {
+		ctx.fields = indexFields(ctx.syntax)
+	}
+	return ctx.fields[field.Pos()]
+}
+
+// indexFields maps the position of each struct field declared in `files` onto
+// its declaration.  The position of an embedded field is that of its type name,
+// as reported by `types.Var.Pos`.
+func indexFields(files []*ast.File) map[token.Pos]*ast.Field {
+	fields := make(map[token.Pos]*ast.Field)
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			s, ok := n.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			for _, f := range s.Fields.List {
+				if len(f.Names) == 0 {
+					fields[embeddedNamePos(f.Type)] = f
+				}
+				for _, name := range f.Names {
+					fields[name.Pos()] = f
+				}
+			}
+			return true
+		})
+	}
+	return fields
+}
+
+// embeddedNamePos returns the position of the type name in the type of an
+// embedded field, e.g. of `Bar` in `*foo.Bar[int]`.
+func embeddedNamePos(expr ast.Expr) token.Pos {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedNamePos(e.X)
+	case *ast.IndexExpr:
+		return embeddedNamePos(e.X)
+	case *ast.IndexListExpr:
+		return embeddedNamePos(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Pos()
+	}
+	return expr.Pos()
+}
+
+// indexDocs maps the position of each name declared at the top level of
+// `files` onto its doc comment.  For a declaration holding a single spec, like
+// `const Foo = 1`, the doc comment is attached to the declaration rather than
+// the spec.
+func indexDocs(files []*ast.File) map[token.Pos]*ast.CommentGroup {
+	docs := make(map[token.Pos]*ast.CommentGroup)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				docs[decl.Name.Pos()] = decl.Doc
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						docs[spec.Name.Pos()] = specDoc(decl, spec.Doc)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							docs[name.Pos()] = specDoc(decl, spec.Doc)
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+func specDoc(decl *ast.GenDecl, doc *ast.CommentGroup) *ast.CommentGroup {
+	if doc == nil && len(decl.Specs) == 1 {
+		return decl.Doc
+	}
+	return doc
+}
+
+// Doc returns the text of the doc comment on the declaration of the type the
+// template is invoked on, without the comment markers or any `//codegen:`
+// directives, or an empty string if it has none.
+func (c *TemplateContext) Doc() string {
+	if docs, ok := c.info.(docInfo); ok {
+		return docs.DocComment(c.Struct.Obj())
+	}
+	return ""
+}
+
+// FieldDoc returns the text of the doc comment above the field `name` of the
+// type the template is invoked on, or an empty string if it has none.  The
+// field may be promoted from an embedded struct, and may be the field invoking
+// the template, e.g. `{{ $.FieldDoc "stackGen" }}`.
+func (c *TemplateContext) FieldDoc(name string) (string, error) {
+	field := structField(c.Struct, name)
+	if field == nil {
+		return "", errors.Errorf("%s has no field %s", c.StructName, name)
+	}
+	if docs, ok := c.info.(docInfo); ok {
+		return docs.FieldDocComment(field), nil
+	}
+	return "", nil
+}
+
+// FieldComment returns the text of the comment following the field `name` of
+// the type the template is invoked on, on the same line, like `FieldDoc`.
+func (c *TemplateContext) FieldComment(name string) (string, error) {
+	field := structField(c.Struct, name)
+	if field == nil {
+		return "", errors.Errorf("%s has no field %s", c.StructName, name)
+	}
+	if docs, ok := c.info.(docInfo); ok {
+		return docs.FieldLineComment(field), nil
+	}
+	return "", nil
+}
@@ -2,9 +2,14 @@ package codegen
 
 import (
 	"bytes"
+	"fmt"
+	"go/constant"
 	"go/token"
 	"go/types"
+	"io/ioutil"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -19,6 +24,30 @@ func RunTemplate(
 	args map[string]string,
 	info TypeInfo,
 ) (string, error) {
+	return runTemplate(parsedTemplate{Template: template}, aStruct, Invocation{Args: args}, info)
+}
+
+// runTemplate runs `template` on `aStruct` for `invocation`, whose gen type may
+// be nil.
+func runTemplate(
+	template parsedTemplate,
+	aStruct *types.Named,
+	invocation Invocation,
+	info TypeInfo,
+) (string, error) {
+	args := invocation.Args
+	var typeArgNames []string
+	if fm := template.frontMatter; fm != nil {
+		var err error
+		if args, err = fm.apply(args, invocation.inherited); err != nil {
+			return "", errors.Wrapf(err, "invalid arguments for %s", template.Name())
+		}
+		for _, pkg := range fm.Imports {
+			info.AddImport(pkg)
+		}
+		typeArgNames = fm.typeArgNames()
+	}
+
 	c := &TemplateContext{
 		Args:         args,
 		StructName:   aStruct.Obj().Name(),
@@ -26,8 +55,18 @@ func RunTemplate(
 		PackageName:  aStruct.Obj().Pkg().Name(),
 		PackagePath:  aStruct.Obj().Pkg().Path(),
 		Struct:       aStruct,
+		Underlying:   aStruct.Underlying(),
+		Kind:         typeKind(aStruct.Underlying()),
+		TypeParams:   typeParams(aStruct),
+		GenType:      invocation.GenType,
 		info:         info,
 	}
+	if invocation.GenType != nil {
+		c.GenTypeArgs = typeArgs(invocation.GenType)
+	}
+	if err := c.resolveTypeArgs(typeArgNames); err != nil {
+		return "", err
+	}
 	var result bytes.Buffer
 	if err := template.Execute(&result, c); err != nil {
 		return "", err
@@ -46,7 +85,25 @@ type TemplateContext struct {
 	TemplateName string
 	PackageName  string
 	PackagePath  string
-	Struct       *types.Named
+	// Struct is the named type the template was invoked on.  Despite its name,
+	// it is not necessarily a struct, see `Kind`.
+	Struct *types.Named
+	// Underlying is the underlying type of `Struct`.
+	Underlying types.Type
+	// Kind describes `Underlying`, and is one of "struct", "interface", "func",
+	// "map", "slice", "array", "chan", "pointer" or "basic".
+	Kind string
+	// TypeParams are the type parameters of `Struct`, if it's generic.  Methods
+	// on a generic type are declared with `$.Receiver`.
+	TypeParams []TypeParam
+	// GenType is the gen type invoking the template, as instantiated by the
+	// invocation if it's generic, e.g. `cacheGen[string]`.
+	GenType *types.Named
+	// GenTypeArgs are the type arguments `GenType` is instantiated with, if any.
+	GenTypeArgs []types.Type
+
+	// argTypes holds the types named by arguments, see `ArgType`.
+	argTypes map[string]types.Type
 
 	info TypeInfo
 }
@@ -57,6 +114,18 @@ func (c *TemplateContext) AddImport(name string) string {
 	return ""
 }
 
+// Import adds an import like `AddImport`, but returns the name the package must
+// be referred to by in the generated code.  This differs from the package's own
+// name when that conflicts with another import, e.g. when importing both
+// `errors` and `github.com/pkg/errors`.
+func (c *TemplateContext) Import(pkg string) string {
+	if namer, ok := c.info.(importNamer); ok {
+		return namer.ImportName(pkg)
+	}
+	c.info.AddImport(pkg)
+	return assumedPackageName(pkg)
+}
+
 func (c *TemplateContext) AddImportType(t types.Type) (string, error) {
 	switch t := t.(type) {
 	case *types.Named:
@@ -64,6 +133,14 @@ func (c *TemplateContext) AddImportType(t types.Type) (string, error) {
 		if pkg != nil {
 			c.AddImport(pkg.Path())
 		}
+		for _, arg := range typeArgs(t) {
+			if _, err := c.AddImportType(arg); err != nil {
+				return "", errors.Wrapf(err, "importing type argument '%s' of type '%s'", arg, t)
+			}
+		}
+	case *types.TypeParam:
+		// Type parameters are declared by the generic type or function using
+		// them, so there's nothing to import.
 	case *types.Map:
 		if _, err := c.AddImportType(t.Key()); err != nil {
 			return "", errors.Wrapf(err, "importing map key type '%s' of type %T", t, t)
@@ -185,21 +262,205 @@ func (c *TemplateContext) Implements(aType types.Type, interfaceName string) (bo
 	return types.Implements(aType, i), nil
 }
 
+// Constant describes a package level constant, as returned by `$.Constants`.
+type Constant struct {
+	Name string
+	// Value is the value of the constant as a go literal, e.g. `3`, `0.5` or
+	// `"red"`.
+	Value string
+	// Doc is the text of the constant's doc comment, if any.
+	Doc string
+	// Index is the position of the constant in declaration order.
+	Index int
+	// Object is the constant itself, for anything not covered above.
+	Object *types.Const
+}
+
+// Constants returns every package level constant of the named type `t`, in the
+// order they are declared, e.g. the values of an enum-like `type Color int`.
+func (c *TemplateContext) Constants(t types.Type) ([]Constant, error) {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil, errors.Errorf("%s is not a named type", t)
+	}
+	pkg := named.Obj().Pkg()
+	if pkg == nil {
+		return nil, errors.Errorf("%s is not declared in a package", t)
+	}
+
+	var consts []*types.Const
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		if k, ok := scope.Lookup(name).(*types.Const); ok && types.Identical(k.Type(), named) {
+			consts = append(consts, k)
+		}
+	}
+	// `Names` are sorted alphabetically, but enum values are usually meant to be
+	// listed in the order they are declared.
+	sort.Slice(consts, func(i, j int) bool { return consts[i].Pos() < consts[j].Pos() })
+
+	docs, _ := c.info.(docInfo)
+	constants := make([]Constant, len(consts))
+	for i, k := range consts {
+		constants[i] = Constant{
+			Name:   k.Name(),
+			Value:  constantLiteral(k.Val()),
+			Index:  i,
+			Object: k,
+		}
+		if docs != nil {
+			constants[i].Doc = docs.DocComment(k)
+		}
+	}
+	return constants, nil
+}
+
+// constantLiteral returns `v` as a go literal.  `ExactString` is exact for
+// integers and strings, but gives fractions like `1/2` for floats, which go
+// would evaluate as integer division.
+func constantLiteral(v constant.Value) string {
+	switch v.Kind() {
+	case constant.Float:
+		return floatLiteral(v)
+	case constant.Complex:
+		return fmt.Sprintf("(%s + %si)", floatLiteral(constant.Real(v)), floatLiteral(constant.Imag(v)))
+	default:
+		return v.ExactString()
+	}
+}
+
+// floatLiteral returns the float constant `v` as a go literal, which is a float
+// even for an integral value, e.g. `2.0`.
+func floatLiteral(v constant.Value) string {
+	f, _ := constant.Float64Val(v)
+	literal := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(literal, ".e") {
+		literal += ".0"
+	}
+	return literal
+}
+
+// TypeString returns the go representation of `t`, qualifying types from other
+// packages by the name they are imported as, and importing them if necessary.
 func (c *TemplateContext) TypeString(t types.Type) string {
-	return types.TypeString(t, func(p *types.Package) string {
-		if p == nil {
-			return ""
-		} else if p.Path() == c.PackagePath {
-			return ""
-		} else {
-			return p.Name()
+	// A generic type that isn't instantiated, like the type the template is
+	// invoked on, is printed with its type parameter list, which isn't valid
+	// outside of its declaration, so it's given its own parameters instead.
+	if named, ok := t.(*types.Named); ok && named.TypeParams().Len() > 0 && named.TypeArgs().Len() == 0 {
+		name := named.Obj().Name()
+		if qualifier := c.qualifier(named.Obj().Pkg()); qualifier != "" {
+			name = qualifier + "." + name
 		}
-	})
+		params := make([]string, named.TypeParams().Len())
+		for i := range params {
+			params[i] = named.TypeParams().At(i).Obj().Name()
+		}
+		return name + "[" + strings.Join(params, ", ") + "]"
+	}
+	return types.TypeString(t, c.qualifier)
+}
+
+// qualifier returns the name types from `p` are qualified by in the generated
+// code, importing it if necessary.
+func (c *TemplateContext) qualifier(p *types.Package) string {
+	if p == nil {
+		return ""
+	} else if p.Path() == c.PackagePath {
+		return ""
+	} else if namer, ok := c.info.(importNamer); ok {
+		return namer.ImportName(p.Path())
+	} else {
+		return p.Name()
+	}
 }
 
+// ParseTemplate parses the template at `path`, along with any partials in the
+// same directory.  Partials are files whose names start with an underscore and
+// end in `.tmpl`, e.g. `_helpers.tmpl`, which hold `define` blocks shared by the
+// templates in their directory.  The template may start with front matter, see
+// `FrontMatter`, which `ReadFrontMatter` reads.
 func ParseTemplate(path string) (*template.Template, error) {
-	name := filepath.Base(path)
-	return template.New(name).Funcs(templateFunctions).ParseFiles(path)
+	t, _, err := parseTemplate(path)
+	return t, err
+}
+
+// parseTemplate is `ParseTemplate`, but also returns the front matter of the
+// template, or nil if it has none.
+func parseTemplate(path string) (*template.Template, *FrontMatter, error) {
+	partials, err := filepath.Glob(filepath.Join(filepath.Dir(path), partialPattern))
+	if err != nil {
+		return nil, nil, err
+	}
+	paths := []string{path}
+	for _, partial := range partials {
+		if partial != path {
+			paths = append(paths, partial)
+		}
+	}
+
+	files := make([]templateFile, len(paths))
+	for i, p := range paths {
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[i] = templateFile{name: filepath.Base(p), content: content}
+	}
+	return parseTemplateFiles(files)
+}
+
+// templateFile is the name and content of a file holding a template.
+type templateFile struct {
+	name    string
+	content []byte
+}
+
+// parseTemplateFiles parses `files` into a single template set, named after the
+// first file, and returns it along with the front matter of the first file, or
+// nil if it has none.  Front matter is ignored in the rest of the files.
+func parseTemplateFiles(files []templateFile) (*template.Template, *FrontMatter, error) {
+	t := template.New(files[0].name).Funcs(templateFunctions)
+	var fm *FrontMatter
+	for i, f := range files {
+		frontMatter, body, ok := splitFrontMatter(f.content)
+		if ok && i == 0 {
+			var err error
+			if fm, err = parseFrontMatter(frontMatter); err != nil {
+				return nil, nil, errors.Wrap(err, f.name)
+			}
+		}
+		tmpl := t
+		if f.name != t.Name() {
+			tmpl = t.New(f.name)
+		}
+		if _, err := tmpl.Parse(string(body)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return withInclude(t), fm, nil
+}
+
+// partialPattern matches the names of partial templates.
+const partialPattern = "_*.tmpl"
+
+// withInclude binds the `include` function to the template set of `t`.
+// `include` executes the named template like the `template` action, but
+// returns its output so it can be piped on, e.g. `{{ include "sig" . | indent
+// 4 }}`.
+func withInclude(t *template.Template) *template.Template {
+	return t.Funcs(template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			var result bytes.Buffer
+			if err := t.ExecuteTemplate(&result, name, data); err != nil {
+				return "", err
+			}
+			// The output may be transformed by the functions it's piped into,
+			// which could mangle line markers, so it's attributed to the line
+			// `include` is called from instead.
+			output, _ := stripLineMarkers(result.String())
+			return output, nil
+		},
+	})
 }
 
 var templateFunctions template.FuncMap
@@ -214,6 +475,13 @@ func init() {
 	templateFunctions["pointerType"] = pointerType
 	templateFunctions["structFields"] = structFields
 	templateFunctions["structField"] = structField
+	templateFunctions["fieldTag"] = fieldTag
+	templateFunctions["fieldTagOptions"] = fieldTagOptions
+	// `include` needs the template set it's called from, so this is replaced
+	// by `withInclude` once a template is parsed.
+	templateFunctions["include"] = func(string, interface{}) (string, error) {
+		return "", errors.New("include is not available outside of a parsed template")
+	}
 }
 
 func catNoSpace(ss ...string) string {
@@ -223,12 +491,18 @@ func catNoSpace(ss ...string) string {
 func typeName(t types.Type) string {
 	switch t := t.(type) {
 	case *types.Named:
-		pkg := t.Obj().Pkg()
-		if pkg != nil {
-			return t.Obj().Pkg().Name() + "." + t.Obj().Name()
-		} else {
-			return t.Obj().Name()
+		name := t.Obj().Name()
+		if pkg := t.Obj().Pkg(); pkg != nil {
+			name = pkg.Name() + "." + name
+		}
+		if args := typeArgs(t); len(args) > 0 {
+			names := make([]string, len(args))
+			for i, arg := range args {
+				names[i] = typeName(arg)
+			}
+			name += "[" + strings.Join(names, ", ") + "]"
 		}
+		return name
 	case interface{ Elem() types.Type }:
 		return typeName(t.Elem())
 	default:
@@ -276,6 +550,32 @@ func structField(t types.Type, fieldName string) *types.Var {
 	}
 }
 
+// typeKind returns the `TemplateContext.Kind` of an underlying type.
+func typeKind(t types.Type) string {
+	switch t.(type) {
+	case *types.Struct:
+		return "struct"
+	case *types.Interface:
+		return "interface"
+	case *types.Signature:
+		return "func"
+	case *types.Map:
+		return "map"
+	case *types.Slice:
+		return "slice"
+	case *types.Array:
+		return "array"
+	case *types.Chan:
+		return "chan"
+	case *types.Pointer:
+		return "pointer"
+	case *types.Basic:
+		return "basic"
+	default:
+		return fmt.Sprintf("%T", t)
+	}
+}
+
 func pointerType(t types.Type) *types.Pointer {
 	return types.NewPointer(t)
 }
@@ -0,0 +1,154 @@
+package codegen
+
+import (
+	"bufio"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TemplatePathEnv is the environment variable the command line tool reads the
+// template search path from, as a list of directories separated by
+// `os.PathListSeparator`.
+const TemplatePathEnv = "CODEGEN_TEMPLATE_PATH"
+
+// TemplatePathConfig is the name of the file listing template search path
+// directories for the packages in the directory containing it and below.  Each
+// line holds a directory, relative to the file.  Blank lines and lines starting
+// with `#` are ignored.  The nearest such file is used, looking no further up
+// than the root of the module.
+const TemplatePathConfig = ".codegen-templates"
+
+// templateCandidates returns the paths the template for `genType` is looked
+// for at, in order:
+//
+//  1. The directory the gen type is declared in.
+//  2. The directory of the gen type's package according to `go list`, which
+//     finds it within the module cache or vendor directory, followed by any
+//     file with the template's name embedded into the package with
+//     `//go:embed`.
+//  3. Each directory of `ctx.TemplatePath`, first under the gen type's package
+//     path, then directly.
+func (ctx *GenContext) templateCandidates(genType *types.Named) []string {
+	name := genType.Obj().Name() + ".tmpl"
+	pkgPath := genType.Obj().Pkg().Path()
+
+	var candidates []string
+	declared := false
+	if fpath := ctx.fset.Position(genType.Obj().Pos()).Filename; fpath != "" {
+		candidate := filepath.Join(filepath.Dir(fpath), name)
+		candidates = append(candidates, candidate)
+		_, err := os.Stat(candidate)
+		declared = err == nil
+	}
+	// Resolving the package's directory runs `go list`, so it's skipped when it
+	// isn't needed.
+	if !declared {
+		if src := ctx.packageSource(pkgPath); src.Dir != "" {
+			candidates = append(candidates, filepath.Join(src.Dir, name))
+			for _, embedded := range src.EmbedFiles {
+				if filepath.Base(embedded) == name {
+					candidates = append(candidates, filepath.Join(src.Dir, embedded))
+				}
+			}
+		}
+	}
+	for _, dir := range ctx.TemplatePath {
+		candidates = append(
+			candidates,
+			filepath.Join(dir, filepath.FromSlash(pkgPath), name),
+			filepath.Join(dir, name),
+		)
+	}
+
+	var unique []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if !seen[candidate] {
+			seen[candidate] = true
+			unique = append(unique, candidate)
+		}
+	}
+	return unique
+}
+
+// packageSource describes where the source of a package is.
+type packageSource struct {
+	Dir string
+	// EmbedFiles are the files embedded into the package, relative to `Dir`.
+	EmbedFiles []string
+}
+
+// packageSource returns the source of the package `pkgPath`, as resolved from
+// the package being generated.  `Dir` is empty if it can't be found.
+func (ctx *GenContext) packageSource(pkgPath string) packageSource {
+	if src, ok := ctx.packageSources[pkgPath]; ok {
+		return src
+	}
+
+	var src packageSource
+	cmd := exec.Command("go", "list", "-e", "-f", "{{.Dir}}{{range .EmbedFiles}}\n{{.}}{{end}}", pkgPath)
+	cmd.Dir = ctx.dir
+	if out, err := cmd.Output(); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		src.Dir = lines[0]
+		for _, embedded := range lines[1:] {
+			src.EmbedFiles = append(src.EmbedFiles, filepath.FromSlash(embedded))
+		}
+	}
+	if ctx.packageSources == nil {
+		ctx.packageSources = make(map[string]packageSource)
+	}
+	ctx.packageSources[pkgPath] = src
+	return src
+}
+
+// readTemplatePathConfig returns the directories listed by the
+// `TemplatePathConfig` file nearest to `dir`, if any.
+func readTemplatePathConfig(dir string) ([]string, error) {
+	for {
+		configPath := filepath.Join(dir, TemplatePathConfig)
+		file, err := os.Open(configPath)
+		if err == nil {
+			defer file.Close()
+			return parseTemplatePathConfig(configPath, file)
+		} else if !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "reading template path config")
+		}
+
+		// Don't look beyond the root of the module.
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return nil, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+func parseTemplatePathConfig(configPath string, r io.Reader) ([]string, error) {
+	var dirs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir := filepath.FromSlash(line)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(configPath), dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading "+configPath)
+	}
+	return dirs, nil
+}
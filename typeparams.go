@@ -0,0 +1,126 @@
+package codegen
+
+import (
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TypeParam describes a type parameter of the type a template is invoked on.
+type TypeParam struct {
+	Name string
+	// Constraint is the type the parameter is constrained by, e.g. `any` or
+	// `comparable`.  Render it with `$.TypeString`.
+	Constraint types.Type
+	// Type is the type parameter itself.
+	Type *types.TypeParam
+}
+
+func typeParams(named *types.Named) []TypeParam {
+	list := named.TypeParams()
+	if list == nil {
+		return nil
+	}
+	params := make([]TypeParam, list.Len())
+	for i := range params {
+		tp := list.At(i)
+		params[i] = TypeParam{
+			Name:       tp.Obj().Name(),
+			Constraint: tp.Constraint(),
+			Type:       tp,
+		}
+	}
+	return params
+}
+
+// TypeParamList returns the type parameter list of the type the template is
+// invoked on as it appears in a declaration, e.g. `[K comparable, V any]`, or
+// an empty string if the type isn't generic.  Use it to declare generic
+// functions of the type, e.g. `func New{{ $.StructName }}{{ $.TypeParamList }}()`.
+func (c *TemplateContext) TypeParamList() string {
+	if len(c.TypeParams) == 0 {
+		return ""
+	}
+	params := make([]string, len(c.TypeParams))
+	for i, tp := range c.TypeParams {
+		params[i] = tp.Name + " " + c.TypeString(tp.Constraint)
+	}
+	return "[" + strings.Join(params, ", ") + "]"
+}
+
+// TypeArgList returns the names of the type parameters of the type the template
+// is invoked on as a type argument list, e.g. `[K, V]`, or an empty string if
+// the type isn't generic.
+func (c *TemplateContext) TypeArgList() string {
+	if len(c.TypeParams) == 0 {
+		return ""
+	}
+	names := make([]string, len(c.TypeParams))
+	for i, tp := range c.TypeParams {
+		names[i] = tp.Name
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// Receiver returns the type the template is invoked on as it appears in the
+// receiver of a method, e.g. `Box[T]`, or just `Box` if the type isn't generic:
+//
+//	func (b *{{ $.Receiver }}) Get() T {
+func (c *TemplateContext) Receiver() string {
+	return c.StructName + c.TypeArgList()
+}
+
+// typeArgs returns the type arguments `named` is instantiated with, if any.
+func typeArgs(named *types.Named) []types.Type {
+	list := named.TypeArgs()
+	if list == nil {
+		return nil
+	}
+	args := make([]types.Type, list.Len())
+	for i := range args {
+		args[i] = list.At(i)
+	}
+	return args
+}
+
+// splitGenTypeName splits the text of a directive into the gen type it names,
+// which may include type arguments, e.g. `cacheGen[string, int]`, and its args.
+func splitGenTypeName(directive string) (genTypeName, argsText string) {
+	depth := 0
+	for i, c := range directive {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case depth == 0 && (c == ' ' || c == '\t'):
+			return directive[:i], strings.TrimSpace(directive[i:])
+		}
+	}
+	return directive, ""
+}
+
+// instantiateGenType instantiates the generic gen type `genType` as written in
+// `expr`, e.g. `cacheGen[string, int]`, resolving its type arguments in the
+// scope of the file containing `pos`.
+func instantiateGenType(
+	genType *types.Named,
+	expr string,
+	pos token.Pos,
+	pkg *types.Package,
+) (*types.Named, error) {
+	if genType.TypeParams().Len() == 0 {
+		return nil, errors.Errorf("%s is not generic", genType.Obj().Name())
+	}
+	tv, err := types.Eval(token.NewFileSet(), pkg, pos, expr)
+	if err != nil {
+		return nil, errors.Wrap(err, "instantiating "+genType.Obj().Name())
+	}
+	instance, ok := tv.Type.(*types.Named)
+	if !ok || !tv.IsType() {
+		return nil, errors.Errorf("expected %s to be a named type", expr)
+	}
+	return instance, nil
+}